@@ -0,0 +1,174 @@
+package squirssi
+
+import (
+	"sync"
+	"time"
+
+	"code.dopame.me/veonik/squircy3/irc"
+)
+
+// AwayLog is a Window that collects highlights and private messages
+// received while the user is away.
+type AwayLog struct {
+	bufferedWindow
+}
+
+func (c *AwayLog) Title() string {
+	return "awaylog"
+}
+
+// AwayManager tracks the away status of the current user.
+type AwayManager struct {
+	away    bool
+	auto    bool
+	message string
+	since   time.Time
+
+	// away message most recently sent to the server.
+	requested string
+
+	// number of messages logged since going away.
+	logged int
+
+	lastInput time.Time
+
+	// last away message seen for each nick, so each is only shown once.
+	seen    map[string]string
+	casemap *CaseMapper
+
+	mu sync.RWMutex
+}
+
+func NewAwayManager(casemap *CaseMapper) *AwayManager {
+	return &AwayManager{lastInput: time.Now(), seen: make(map[string]string), casemap: casemap}
+}
+
+// Away returns true and the away message if the current user is away.
+func (am *AwayManager) Away() (bool, string) {
+	am.mu.RLock()
+	defer am.mu.RUnlock()
+	return am.away, am.message
+}
+
+// Request records the away message being sent to the server.
+// An empty message requests to no longer be away.
+func (am *AwayManager) Request(message string, auto bool) {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	am.requested = message
+	am.auto = auto && message != ""
+}
+
+func (am *AwayManager) setAway() {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	am.message = am.requested
+	if am.away {
+		return
+	}
+	am.away = true
+	am.since = time.Now()
+	am.logged = 0
+}
+
+// setBack clears the away status, returning the time spent away
+// and the number of messages logged in the meantime.
+func (am *AwayManager) setBack() (time.Duration, int) {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	if !am.away {
+		return 0, 0
+	}
+	am.away = false
+	am.auto = false
+	am.message = ""
+	return time.Since(am.since), am.logged
+}
+
+// Input records user activity, returning true if the user is currently auto-away.
+func (am *AwayManager) Input() bool {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	am.lastInput = time.Now()
+	return am.auto
+}
+
+// Idle returns how long it has been since the last user input.
+func (am *AwayManager) Idle() time.Duration {
+	am.mu.RLock()
+	defer am.mu.RUnlock()
+	return time.Since(am.lastInput)
+}
+
+// SeenAway returns true if message was already shown for nick.
+func (am *AwayManager) SeenAway(nick, message string) bool {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	k := am.casemap.Fold(nick)
+	if am.seen[k] == message {
+		return true
+	}
+	am.seen[k] = message
+	return false
+}
+
+func (am *AwayManager) incLogged() {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	am.logged++
+}
+
+// awayLog returns the AwayLog window, creating it if necessary.
+func (srv *Server) awayLog() Window {
+	if win := srv.windows.Named("awaylog"); win != nil {
+		return win
+	}
+	win := &AwayLog{newBufferedWindow("awaylog", srv.events)}
+	srv.windows.Append(win)
+	return win
+}
+
+// logAway copies a message into the AwayLog if the user is away.
+func (srv *Server) logAway(target string, nick Nick, message Message) {
+	if away, _ := srv.away.Away(); !away {
+		return
+	}
+	srv.away.incLogged()
+	WriteAwayLog(srv.awayLog(), target, nick, message)
+}
+
+// startAutoAway periodically checks for user inactivity and marks the user
+// as away after the configured idle time.
+func (srv *Server) startAutoAway() {
+	t := time.NewTicker(15 * time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-srv.done:
+			return
+		case <-t.C:
+			s := srv.settings.Get()
+			if s.AutoAway.Duration <= 0 || srv.away.Idle() < s.AutoAway.Duration {
+				continue
+			}
+			if away, _ := srv.away.Away(); away || srv.CurrentNick() == "" {
+				continue
+			}
+			srv.setAway(s.AutoAwayMessage, true)
+		}
+	}
+}
+
+// setAway sends an AWAY command to the server. An empty message marks the
+// user as no longer away.
+func (srv *Server) setAway(message string, auto bool) {
+	srv.away.Request(message, auto)
+	srv.IRCDoAsync(func(conn *irc.Connection) error {
+		if message == "" {
+			conn.SendRaw("AWAY")
+		} else {
+			conn.SendRawf("AWAY :%s", message)
+		}
+		return nil
+	})
+}
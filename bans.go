@@ -0,0 +1,207 @@
+package squirssi
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"code.dopame.me/veonik/squircy3/irc"
+	"github.com/pkg/errors"
+)
+
+// A ModeListEntry is a single entry in a channel list mode, such as a ban.
+type ModeListEntry struct {
+	Mask  string
+	SetBy string
+	SetAt time.Time
+}
+
+// A ModeListManager collects the entries of channel list modes, such as
+// bans, until the end of the list is received.
+type ModeListManager struct {
+	values  map[string][]ModeListEntry
+	casemap *CaseMapper
+
+	mu sync.Mutex
+}
+
+func NewModeListManager(casemap *CaseMapper) *ModeListManager {
+	return &ModeListManager{
+		values:  make(map[string][]ModeListEntry),
+		casemap: casemap,
+	}
+}
+
+// Reset forgets all lists being collected.
+func (mm *ModeListManager) Reset() {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	mm.values = make(map[string][]ModeListEntry)
+}
+
+func (mm *ModeListManager) key(channel, mode string) string {
+	return mm.casemap.Fold(channel) + " " + mode
+}
+
+// Add adds e to the list for mode in channel.
+func (mm *ModeListManager) Add(channel, mode string, e ModeListEntry) {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	k := mm.key(channel, mode)
+	mm.values[k] = append(mm.values[k], e)
+}
+
+// Take returns and forgets the entries collected for mode in channel.
+func (mm *ModeListManager) Take(channel, mode string) []ModeListEntry {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	k := mm.key(channel, mode)
+	entries := mm.values[k]
+	delete(mm.values, k)
+	return entries
+}
+
+// isMask returns true if s looks like a hostmask rather than a nickname.
+func isMask(s string) bool {
+	return strings.ContainsAny(s, "!@*?")
+}
+
+// domainMask returns a wildcard mask matching the domain of host.
+func domainMask(host string) string {
+	if strings.Contains(host, "/") {
+		// a cloak, the whole thing identifies the user
+		return host
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip.To4() != nil {
+			return host[:strings.LastIndex(host, ".")] + ".*"
+		}
+		// ipv6, mask everything after the /64 prefix. The address is
+		// expanded first so that a "::" does not hide any of the prefix.
+		ip = ip.To16()
+		parts := make([]string, 4)
+		for i := range parts {
+			parts[i] = strconv.FormatUint(uint64(ip[2*i])<<8|uint64(ip[2*i+1]), 16)
+		}
+		return strings.Join(parts, ":") + ":*"
+	}
+	parts := strings.Split(host, ".")
+	if len(parts) < 3 {
+		return host
+	}
+	return "*." + strings.Join(parts[1:], ".")
+}
+
+// BanMask builds a ban mask for u using the given mask types.
+// Mask types are any of "nick", "user", "host" and "domain".
+func BanMask(u UserInfo, types []string) string {
+	nick, user, host := "*", "*", "*"
+	for _, t := range types {
+		switch strings.ToLower(t) {
+		case "nick":
+			nick = u.Nick
+		case "user":
+			user = "*" + strings.TrimLeft(u.User, "~")
+		case "host":
+			host = u.Host
+		case "domain":
+			if host == "*" {
+				host = domainMask(u.Host)
+			}
+		}
+	}
+	return nick + "!" + user + "@" + host
+}
+
+type userhostQuery struct {
+	nick string
+	fn   func(u UserInfo, ok bool)
+}
+
+// A UserhostManager tracks pending USERHOST queries.
+type UserhostManager struct {
+	pending []userhostQuery
+
+	mu sync.Mutex
+}
+
+func NewUserhostManager() *UserhostManager {
+	return &UserhostManager{}
+}
+
+func (um *UserhostManager) Reset() {
+	um.mu.Lock()
+	defer um.mu.Unlock()
+	um.pending = nil
+}
+
+func (um *UserhostManager) push(q userhostQuery) {
+	um.mu.Lock()
+	defer um.mu.Unlock()
+	um.pending = append(um.pending, q)
+}
+
+func (um *UserhostManager) pop() (userhostQuery, bool) {
+	um.mu.Lock()
+	defer um.mu.Unlock()
+	if len(um.pending) == 0 {
+		return userhostQuery{}, false
+	}
+	q := um.pending[0]
+	um.pending = um.pending[1:]
+	return q, true
+}
+
+// lookupUser calls fn with the user and host of nick, querying the server
+// with USERHOST if it is not already known or in a recent WHOIS result.
+func (srv *Server) lookupUser(nick string, fn func(u UserInfo, ok bool)) {
+	if u, ok := srv.users.Get(nick); ok && u.User != "" && u.Host != "" {
+		fn(u, true)
+		return
+	}
+	if r, ok := srv.whoises.Cached(nick); ok && r.User != "" && r.Host != "" {
+		fn(r.UserInfo, true)
+		return
+	}
+	srv.userhosts.push(userhostQuery{nick, fn})
+	srv.IRCDoAsync(func(conn *irc.Connection) error {
+		conn.SendRawf("USERHOST %s", nick)
+		return nil
+	})
+}
+
+// resolveBanMask calls fn with a mask for target. target may already be a
+// mask, otherwise it is treated as a nick and a mask is built from the
+// configured ban mask types.
+func (srv *Server) resolveBanMask(target string, fn func(mask string, err error)) {
+	if isMask(target) {
+		fn(target, nil)
+		return
+	}
+	types := srv.settings.Get().BanMask
+	srv.lookupUser(target, func(u UserInfo, ok bool) {
+		if !ok {
+			fn("", errors.Errorf("unable to determine host for %s", target))
+			return
+		}
+		fn(BanMask(u, types), nil)
+	})
+}
+
+// parseUserhost parses a single RPL_USERHOST reply in the form
+// nick[*]=[+-]user@host.
+func parseUserhost(s string) (UserInfo, bool) {
+	p := strings.SplitN(s, "=", 2)
+	if len(p) != 2 || len(p[1]) < 1 {
+		return UserInfo{}, false
+	}
+	nick := strings.TrimSuffix(p[0], "*")
+	away := p[1][0] == '-'
+	uh := strings.SplitN(p[1][1:], "@", 2)
+	if len(uh) != 2 {
+		return UserInfo{}, false
+	}
+	return UserInfo{Nick: nick, User: uh[0], Host: uh[1], Away: away}, true
+}
@@ -1,6 +1,7 @@
 package squirssi
 
 import (
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -12,10 +13,54 @@ import (
 
 type Command func(*Server, []string)
 
+// modeListHandler returns a Command that fetches and prints the given list mode.
+func modeListHandler(mode string) Command {
+	return func(srv *Server, args []string) {
+		args = guessTargetInArgs(srv, args, 1)
+		channel := args[1]
+		if !srv.isChannel(channel) {
+			logrus.Warnf("%s: unable to determine current channel", args[0])
+			return
+		}
+		if !strings.Contains(srv.modeTypes().List, mode) {
+			logrus.Warnf("%s: the server does not support +%s lists", args[0], mode)
+			return
+		}
+		srv.sendLabeled(srv.windows.Active(), "MODE "+channel+" +"+mode)
+	}
+}
+
+// modeHandler returns a Command that applies mode to one or more nicks or
+// wildcard patterns in the given or active channel.
 func modeHandler(mode string) Command {
 	return func(srv *Server, args []string) {
-		args = append(append(append([]string{}, args[:1]...), mode), args[1:]...)
-		modeChange(srv, args)
+		var channel string
+		if len(args) > 1 && srv.isChannel(args[1]) {
+			channel = args[1]
+			args = append(args[:1:1], args[2:]...)
+		} else if win := srv.windows.Active(); win != nil {
+			if _, ok := win.(*Channel); ok {
+				channel = win.Title()
+			}
+		}
+		if channel == "" {
+			logrus.Warnf("%s: expected a channel", args[0])
+			return
+		}
+		if len(args) < 2 {
+			logrus.Warnf("%s: expected at least one nick", args[0])
+			return
+		}
+		lines := srv.massMode(channel, mode, args[1:])
+		if len(lines) == 0 {
+			return
+		}
+		srv.IRCDoAsync(func(conn *irc.Connection) error {
+			for _, l := range lines {
+				conn.SendRaw(l)
+			}
+			return nil
+		})
 	}
 }
 
@@ -29,25 +74,42 @@ var builtInsOrdered = []string{
 	"wc",
 	"join",
 	"part",
+	"knock",
 	"invite",
 	"topic",
 	"whois",
+	"who",
 	"names",
+	"list",
 	"nick",
+	"away",
+	"channel",
+	"notify",
+	"tlsinfo",
 	"me",
 	"msg",
+	"reply",
+	"react",
 	"ctcp",
 	"notice",
+	"ignore",
+	"unignore",
 	"kick",
 	"mode",
 	"ban",
+	"kickban",
 	"unban",
+	"banlist",
+	"exceptlist",
+	"invitelist",
+	"quietlist",
 	"op",
 	"deop",
 	"voice",
 	"devoice",
 	"mute",
 	"unmute",
+	"set",
 	"echo",
 	"raw",
 	"eval",
@@ -55,27 +117,47 @@ var builtInsOrdered = []string{
 }
 
 var builtIns = map[string]Command{
-	"help":   helpCmd,
-	"?":      helpCmd,
-	"exit":   exitProgram,
-	"w":      selectWindow,
-	"wc":     closeWindow,
-	"join":   joinChannel,
-	"part":   partChannel,
-	"invite": inviteTarget,
-	"topic":  topicChange,
-	"whois":  whoisNick,
-	"names":  namesChannel,
-	"nick":   changeNick,
-	"me":     actionTarget,
-	"msg":    msgTarget,
-	"ctcp":   ctcpTarget,
-	"notice": noticeTarget,
+	"help":    helpCmd,
+	"?":       helpCmd,
+	"exit":    exitProgram,
+	"w":       selectWindow,
+	"wc":      closeWindow,
+	"join":    joinChannel,
+	"part":    partChannel,
+	"knock":   knockChannel,
+	"invite":  inviteTarget,
+	"topic":   topicChange,
+	"whois":   whoisNick,
+	"who":     whoQuery,
+	"names":   namesChannel,
+	"list":    listChannels,
+	"nick":    changeNick,
+	"away":    awayStatus,
+	"channel": channelSettings,
+	"notify":  notifyList,
+	"tlsinfo": tlsInfo,
+	"set":     setSetting,
+	"me":      actionTarget,
+	"msg":     msgTarget,
+	"reply":   replyMessage,
+	"react":   reactMessage,
+	"ctcp":    ctcpTarget,
+	"notice":  noticeTarget,
+
+	"ignore":   ignoreUser,
+	"unignore": unignoreUser,
 
 	"kick":    kickTarget,
 	"mode":    modeChange,
-	"ban":     modeHandler("+b"),
-	"unban":   modeHandler("-b"),
+	"ban":     banTarget,
+	"kickban": kickbanTarget,
+	"unban":   unbanTarget,
+
+	"banlist":    modeListHandler("b"),
+	"exceptlist": modeListHandler("e"),
+	"invitelist": modeListHandler("I"),
+	"quietlist":  modeListHandler("q"),
+
 	"op":      modeHandler("+o"),
 	"deop":    modeHandler("-o"),
 	"voice":   modeHandler("+v"),
@@ -92,14 +174,12 @@ var builtIns = map[string]Command{
 		_, _ = win.WriteString(strings.Join(args[1:], " "))
 	},
 	"raw": func(srv *Server, args []string) {
-		srv.IRCDoAsync(func(conn *irc.Connection) error {
-			conn.SendRaw(strings.Join(args[1:], " "))
-			win := srv.windows.Active()
-			if win != nil {
-				WriteRaw(win, "-> "+strings.Join(args[1:], " "))
-			}
-			return nil
-		})
+		win := srv.windows.Active()
+		// replies are shown in the active window if the server supports labels
+		srv.sendLabeled(win, strings.Join(args[1:], " "))
+		if win != nil {
+			WriteRaw(win, "-> "+strings.Join(args[1:], " "))
+		}
 	},
 	"eval": func(srv *Server, args []string) {
 		win := srv.windows.Active()
@@ -121,27 +201,44 @@ var builtInDescriptions = map[string]string{
 	"exit":       "Exits squirssi.",
 	"w":          "Switches to the given window by number.",
 	"wc":         "Closes the given window by number, or the currently active window.",
-	"join":       "Attempts to join the given channel.",
+	"join":       "Attempts to join the given channels, with optional keys: #a,#b keyA,keyB.",
+	"knock":      "Requests an invite to the given invite only channel.",
 	"part":       "Parts the given channel.",
 	"invite":     "Invites a user to the given channel.",
-	"topic":      "Sets the topic for the given channel, or the currently active window.",
+	"topic":      "Sets the topic for the given channel, or the currently active window. Use -history to list previous topics.",
 	"whois":      "Runs a WHOIS query on the given nickname.",
+	"who":        "Runs a WHO query on the given mask, or the current channel.",
 	"names":      "Runs a NAMES query on the given channel.",
+	"list":       "Browses channels on the server: [-min N] [-max N] [pattern].",
 	"nick":       "Changes the current nickname.",
+	"tlsinfo":    "Shows the TLS version, cipher and certificate chain of the server.",
+	"notify":     "Manages the notify list: add [-network name] <nick...>, del [-network name] <nick...>, or list [-network name].",
+	"channel":    "Manages channel settings: add [-network name] [-key key] [-(no)autojoin] [-(no)rejoin] [-rejoin-delay 2s] [-hidejoins|-showjoins] [-hideparts|-showparts] [-(no)log] [-highlight a,b] <#channel>, remove [-network name] <#channel>, or list [-network name].",
+	"away":       "Marks yourself as away with the given reason, or back if no reason is given.",
+	"set":        "Changes a setting, or lists current settings.",
 	"me":         "Performs an action message in the current window.",
 	"msg":        "Sends a message to the given target.",
+	"reply":      "Replies to the selected message: the latest, or the bottom line when scrolled up.",
+	"react":      "Reacts to the selected message with the given text or emoji.",
 	"ctcp":       "Sends a CTCP query to the given target.",
 	"notice":     "Sends a NOTICE to the given target.",
+	"ignore":     "Ignores a hostmask: [-regexp] [-channels #a,#b] [-time 1h] <mask> [levels...], or lists ignores.",
+	"unignore":   "Removes an ignore by number or mask.",
 	"kick":       "Kicks a user from the given channel.",
 	"mode":       "Sets mode on a channel or the current user.",
-	"ban":        "Bans (+b) a user from the given channel.",
-	"unban":      "Unbans (-b) a user from the given channel.",
-	"op":         "Ops (+o) a user on the given channel.",
-	"deop":       "Deops (-o) a user on the given channel.",
-	"voice":      "Voices (+v) a user on the given channel.",
-	"devoice":    "Devoices (-v) a user on the given channel.",
-	"mute":       "Mutes (+q) a user on the given channel.",
-	"unmute":     "Unmutes (-q) a user on the given channel.",
+	"ban":        "Bans (+b) a nick or mask from the given channel.",
+	"kickban":    "Bans (+b) and kicks a user from the given channel.",
+	"unban":      "Unbans (-b) a nick, mask or ban list number, or lists bans.",
+	"banlist":    "Lists bans (+b) on the given channel.",
+	"exceptlist": "Lists ban exceptions (+e) on the given channel.",
+	"invitelist": "Lists invite exceptions (+I) on the given channel.",
+	"quietlist":  "Lists quiets (+q) on the given channel.",
+	"op":         "Ops (+o) nicks or wildcard patterns on the given channel.",
+	"deop":       "Deops (-o) nicks or wildcard patterns on the given channel.",
+	"voice":      "Voices (+v) nicks or wildcard patterns on the given channel.",
+	"devoice":    "Devoices (-v) nicks or wildcard patterns on the given channel.",
+	"mute":       "Mutes (+q) nicks or wildcard patterns on the given channel.",
+	"unmute":     "Unmutes (-q) nicks or wildcard patterns on the given channel.",
 	"connect":    "Connects to the configured IRC server.",
 	"disconnect": "Disconnects from the connected IRC server.",
 	"echo":       "Writes any arguments given to the currently active window.",
@@ -195,26 +292,33 @@ func exitProgram(srv *Server, _ []string) {
 }
 
 func topicChange(srv *Server, args []string) {
+	if len(args) > 1 && args[1] == "-history" {
+		args = guessTargetInArgs(srv, args[1:], 1)
+		if args[1] == "" {
+			logrus.Warnln("topic: unable to determine current channel")
+			return
+		}
+		win := srv.windows.Named(args[1])
+		if win == nil {
+			win = srv.windows.Active()
+		}
+		WriteTopicHistory(win, args[1], srv.topics.History(srv.Network(), args[1]))
+		return
+	}
 	args = guessTargetInArgs(srv, args, 1)
 	target := args[1]
+	win := srv.windows.Active()
 	if len(args) == 2 {
-		srv.IRCDoAsync(func(conn *irc.Connection) error {
-			conn.SendRawf("TOPIC %s", target)
-			return nil
-		})
+		srv.sendLabeled(win, "TOPIC "+target)
 		return
 	}
-	topic := strings.Join(args[2:], " ")
-	srv.IRCDoAsync(func(conn *irc.Connection) error {
-		conn.SendRawf("TOPIC %s :%s", target, topic)
-		return nil
-	})
+	srv.sendLabeled(win, "TOPIC "+target+" :"+strings.Join(args[2:], " "))
 }
 
 func kickTarget(srv *Server, args []string) {
 	args = guessTargetInArgs(srv, args, 1)
 	target := args[1]
-	if len(target) > 0 && target[0] != '#' {
+	if target != "" && !srv.isChannel(target) {
 		logrus.Warnln("kick: unable to determine current channel")
 		return
 	}
@@ -239,6 +343,17 @@ func modeChange(srv *Server, args []string) {
 	}
 	target := args[1]
 	modes := args[2:]
+	if mode, ok := repeatedMode(modes); ok && len(modes) > 2 && srv.isChannel(target) {
+		// the same mode for several nicks, batch it like /op and friends
+		lines := srv.massMode(target, mode, modes[1:])
+		srv.IRCDoAsync(func(conn *irc.Connection) error {
+			for _, l := range lines {
+				conn.SendRaw(l)
+			}
+			return nil
+		})
+		return
+	}
 	var irc324Handler event.Handler
 	var irc329Handler event.Handler
 	if len(modes) == 0 || len(modes[0]) == 0 {
@@ -261,16 +376,17 @@ func modeChange(srv *Server, args []string) {
 			srv.events.Unbind("irc.329", irc329Handler)
 		})
 	}
-	srv.IRCDoAsync(func(conn *irc.Connection) error {
-		if irc324Handler != nil {
-			srv.events.Bind("irc.324", irc324Handler)
-		}
-		if irc329Handler != nil {
-			srv.events.Bind("irc.329", irc329Handler)
-		}
-		conn.Mode(target, modes...)
-		return nil
-	})
+	if irc324Handler != nil {
+		srv.events.Bind("irc.324", irc324Handler)
+	}
+	if irc329Handler != nil {
+		srv.events.Bind("irc.329", irc329Handler)
+	}
+	line := "MODE " + target
+	if len(modes) > 0 {
+		line += " " + strings.Join(modes, " ")
+	}
+	srv.sendLabeled(srv.windows.Active(), line)
 }
 
 func selectWindow(srv *Server, args []string) {
@@ -316,7 +432,7 @@ func guessTargetInArgs(srv *Server, args []string, targetIndex int) []string {
 	if targetIndex < 0 {
 		return args
 	}
-	if len(args) < targetIndex+1 || !strings.HasPrefix(args[targetIndex], "#") {
+	if len(args) < targetIndex+1 || !srv.isChannel(args[targetIndex]) {
 		win := srv.windows.Active()
 		t := ""
 		if win != nil && win.Title() != "status" {
@@ -330,12 +446,32 @@ func guessTargetInArgs(srv *Server, args []string, targetIndex int) []string {
 func joinChannel(srv *Server, args []string) {
 	args = guessTargetInArgs(srv, args, 1)
 	target := args[1]
-	if len(target) > 0 && target[0] != '#' {
+	if !srv.isChannel(target) {
 		logrus.Warnln("join: unable to determine current channel")
 		return
 	}
+	channels := strings.Split(target, ",")
+	var keys []string
+	if len(args) > 2 {
+		keys = strings.Split(args[2], ",")
+	}
+	srv.join(channels, keys)
+}
+
+func knockChannel(srv *Server, args []string) {
+	args = guessTargetInArgs(srv, args, 1)
+	target := args[1]
+	if !srv.isChannel(target) {
+		logrus.Warnln("knock: expected a channel")
+		return
+	}
+	message := strings.Join(args[2:], " ")
 	srv.IRCDoAsync(func(conn *irc.Connection) error {
-		conn.Join(target)
+		if message != "" {
+			conn.SendRawf("KNOCK %s :%s", target, message)
+		} else {
+			conn.SendRawf("KNOCK %s", target)
+		}
 		return nil
 	})
 }
@@ -343,7 +479,7 @@ func joinChannel(srv *Server, args []string) {
 func partChannel(srv *Server, args []string) {
 	args = guessTargetInArgs(srv, args, 1)
 	target := args[1]
-	if len(target) > 0 && target[0] != '#' {
+	if target != "" && !srv.isChannel(target) {
 		logrus.Warnln("part: unable to determine current channel")
 		return
 	}
@@ -356,7 +492,7 @@ func partChannel(srv *Server, args []string) {
 func inviteTarget(srv *Server, args []string) {
 	args = guessTargetInArgs(srv, args, 1)
 	target := args[1]
-	if len(target) > 0 && target[0] != '#' {
+	if target != "" && !srv.isChannel(target) {
 		logrus.Warnln("invite: unable to determine current channel")
 		return
 	}
@@ -372,16 +508,13 @@ func whoisNick(srv *Server, args []string) {
 		logrus.Warnln("whois: expected one argument")
 		return
 	}
-	srv.IRCDoAsync(func(conn *irc.Connection) error {
-		conn.SendRawf("WHOIS %s", args[1])
-		return nil
-	})
+	srv.whois(args[1], srv.windows.Active())
 }
 
 func namesChannel(srv *Server, args []string) {
 	args = guessTargetInArgs(srv, args, 1)
 	target := args[1]
-	if len(target) > 0 && target[0] != '#' {
+	if target != "" && !srv.isChannel(target) {
 		logrus.Warnln("names: unable to determine current channel")
 		return
 	}
@@ -390,26 +523,7 @@ func namesChannel(srv *Server, args []string) {
 		logrus.Warnln("names: no window named", target)
 		return
 	}
-	irc353Handler := event.HandlerFunc(func(ev *event.Event) {
-		args := ev.Data["Args"].([]string)
-		chanName := args[2]
-		nicks := args[3]
-		logrus.Infof("NAMES %s: %s", chanName, nicks)
-	})
-	var irc366Handler event.Handler
-	irc366Handler = event.HandlerFunc(func(ev *event.Event) {
-		args := ev.Data["Args"].([]string)
-		chanName := args[1]
-		logrus.Infof("END NAMES %s", chanName)
-		srv.events.Unbind("irc.353", irc353Handler)
-		srv.events.Unbind("irc.366", irc366Handler)
-	})
-	srv.events.Bind("irc.353", irc353Handler)
-	srv.events.Bind("irc.366", irc366Handler)
-	srv.IRCDoAsync(func(conn *irc.Connection) error {
-		conn.SendRawf("NAMES :%s", target)
-		return nil
-	})
+	srv.requestNames(win)
 }
 
 func changeNick(srv *Server, args []string) {
@@ -417,6 +531,8 @@ func changeNick(srv *Server, args []string) {
 		logrus.Warnln("nick: expected one argument")
 		return
 	}
+	// an explicit nick change becomes the nick to regain
+	srv.nicks.SetPrimary(args[1])
 	srv.IRCDoAsync(func(conn *irc.Connection) error {
 		conn.Nick(args[1])
 		return nil
@@ -429,12 +545,7 @@ func actionTarget(srv *Server, args []string) {
 	if window == nil || window.Title() == "status" {
 		return
 	}
-	srv.IRCDoAsync(func(conn *irc.Connection) error {
-		conn.Action(window.Title(), message)
-		return nil
-	})
-	myNick := MyNick(srv.CurrentNick())
-	WriteAction(window, myNick, MyMessage(message))
+	srv.sendMessage(window, window.Title(), message, message, true, MessageInfo{})
 }
 
 func msgTarget(srv *Server, args []string) {
@@ -447,12 +558,9 @@ func msgTarget(srv *Server, args []string) {
 		return
 	}
 	message := strings.Join(args[2:], " ")
-	srv.IRCDoAsync(func(conn *irc.Connection) error {
-		conn.Privmsg(target, message)
-		return nil
-	})
+	shown := message
 	window := srv.windows.Named(target)
-	if !strings.HasPrefix(target, "#") {
+	if !srv.isChannel(target) {
 		// direct message!
 		if window == nil {
 			dm := &DirectMessage{
@@ -462,13 +570,63 @@ func msgTarget(srv *Server, args []string) {
 			window = dm
 		}
 	}
-	myNick := MyNick(srv.CurrentNick())
 	if window == nil {
 		// no window for this but we might still have sent the message, so write it to the status window
 		window = srv.windows.Index(0)
-		message = target + " -> " + message
+		shown = target + " -> " + message
+	}
+	srv.sendMessage(window, target, message, shown, false, MessageInfo{})
+}
+
+// activeConversation returns the active window if it is a channel or query.
+func activeConversation(srv *Server) (Window, bool) {
+	win := srv.windows.Active()
+	switch win.(type) {
+	case *Channel, *DirectMessage:
+		return win, true
+	}
+	return nil, false
+}
+
+func replyMessage(srv *Server, args []string) {
+	if len(args) < 2 {
+		logrus.Warnln("reply: expected a message")
+		return
+	}
+	win, ok := activeConversation(srv)
+	if !ok {
+		logrus.Warnln("reply: the current window has no messages to reply to")
+		return
+	}
+	parent, ok := selectedMessage(win)
+	if !ok {
+		logrus.Warnln("reply: no message with an ID is selected")
+		return
 	}
-	WritePrivmsg(window, myNick, MyMessage(message))
+	message := strings.Join(args[1:], " ")
+	srv.sendMessage(win, win.Title(), message, message, false, parent)
+}
+
+func reactMessage(srv *Server, args []string) {
+	if len(args) != 2 {
+		logrus.Warnln("react: expected a reaction")
+		return
+	}
+	win, ok := activeConversation(srv)
+	if !ok {
+		logrus.Warnln("react: the current window has no messages to react to")
+		return
+	}
+	if !srv.clientTagAllowed("draft/react") {
+		logrus.Warnln("react: the server does not allow reactions")
+		return
+	}
+	parent, ok := selectedMessage(win)
+	if !ok {
+		logrus.Warnln("react: no message with an ID is selected")
+		return
+	}
+	srv.sendReaction(win, parent.ID, args[1])
 }
 
 func noticeTarget(srv *Server, args []string) {
@@ -482,7 +640,7 @@ func noticeTarget(srv *Server, args []string) {
 	}
 	message := strings.Join(args[2:], " ")
 	srv.IRCDoAsync(func(conn *irc.Connection) error {
-		conn.Notice(target, message)
+		srv.sendNotice(conn, target, message)
 		return nil
 	})
 	window := srv.windows.Named(target)
@@ -514,3 +672,490 @@ func ctcpTarget(srv *Server, args []string) {
 	}
 	WriteCTCP(window, SomeTarget(target, srv.CurrentNick()), true, message)
 }
+
+func ignoreUser(srv *Server, args []string) {
+	win := srv.windows.Active()
+	if win == nil {
+		return
+	}
+	if len(args) < 2 {
+		WriteIgnoreList(win, srv.ignores.Entries())
+		return
+	}
+	ig := Ignore{Levels: IgnoreAll}
+	var rest []string
+	for i := 1; i < len(args); i++ {
+		switch args[i] {
+		case "-regexp":
+			ig.Regexp = true
+		case "-channels":
+			i++
+			if i >= len(args) {
+				logrus.Warnln("ignore: -channels expects a comma separated list of channels")
+				return
+			}
+			ig.Channels = strings.Split(args[i], ",")
+		case "-time":
+			i++
+			if i >= len(args) {
+				logrus.Warnln("ignore: -time expects a duration")
+				return
+			}
+			d, err := time.ParseDuration(args[i])
+			if err != nil {
+				logrus.Warnln("ignore: invalid duration:", err)
+				return
+			}
+			ig.Expires = time.Now().Add(d)
+		default:
+			rest = append(rest, args[i])
+		}
+	}
+	if len(rest) == 0 {
+		logrus.Warnln("ignore: expected a mask")
+		return
+	}
+	ig.Mask = rest[0]
+	if len(rest) > 1 {
+		levels, err := ParseIgnoreLevels(rest[1:])
+		if err != nil {
+			logrus.Warnln("ignore:", err)
+			return
+		}
+		ig.Levels = levels
+	}
+	if err := srv.ignores.Add(ig); err != nil {
+		logrus.Warnln("ignore:", err)
+		return
+	}
+	WriteIgnore(win, ig)
+}
+
+func unignoreUser(srv *Server, args []string) {
+	if len(args) < 2 {
+		logrus.Warnln("unignore: expected one argument")
+		return
+	}
+	win := srv.windows.Active()
+	if win == nil {
+		return
+	}
+	var ig Ignore
+	var err error
+	if idx, nerr := strconv.Atoi(args[1]); nerr == nil {
+		ig, err = srv.ignores.Remove(idx)
+	} else {
+		ig, err = srv.ignores.RemoveMask(args[1])
+	}
+	if err != nil {
+		logrus.Warnln("unignore:", err)
+		return
+	}
+	WriteUnignore(win, ig)
+}
+
+func awayStatus(srv *Server, args []string) {
+	srv.setAway(strings.Join(args[1:], " "), false)
+}
+
+func setSetting(srv *Server, args []string) {
+	win := srv.windows.Active()
+	if win == nil {
+		return
+	}
+	if len(args) < 3 {
+		all, err := srv.settings.All()
+		if err != nil {
+			logrus.Warnln("set: failed to list settings:", err)
+			return
+		}
+		for _, s := range all {
+			if len(args) < 2 || s.Name == args[1] {
+				WriteSetting(win, s.Name, s.Value)
+			}
+		}
+		return
+	}
+	if err := srv.settings.Set(args[1], strings.Join(args[2:], " ")); err != nil {
+		logrus.Warnln("set:", err)
+		return
+	}
+	setSetting(srv, args[:2])
+}
+
+func listChannels(srv *Server, args []string) {
+	opts := ChannelListOptions{}
+	for i := 1; i < len(args); i++ {
+		switch args[i] {
+		case "-min", "-max":
+			if i+1 >= len(args) {
+				logrus.Warnf("list: %s expects a number", args[i])
+				return
+			}
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				logrus.Warnf("list: %s expects a number", args[i])
+				return
+			}
+			if args[i] == "-min" {
+				opts.MinUsers = n
+			} else {
+				opts.MaxUsers = n
+			}
+			i++
+		default:
+			opts.Pattern = args[i]
+		}
+	}
+	cl, ok := srv.windows.Named("list").(*ChannelList)
+	if !ok {
+		cl = NewChannelList(srv.events)
+		srv.windows.Append(cl)
+	}
+	cl.Reset(opts)
+	srv.windows.SelectIndex(srv.windows.IndexOf(cl))
+	srv.sendLabeled(cl, "LIST")
+}
+
+func whoQuery(srv *Server, args []string) {
+	if len(args) < 2 || args[1] == "" {
+		args = guessTargetInArgs(srv, args[:1], 1)
+	}
+	mask := args[1]
+	if mask == "" {
+		logrus.Warnln("who: expected a mask or channel")
+		return
+	}
+	win := srv.windows.Active()
+	if win == nil {
+		return
+	}
+	srv.who(mask, win)
+}
+
+func banTarget(srv *Server, args []string) {
+	args = guessTargetInArgs(srv, args, 1)
+	channel := args[1]
+	if !srv.isChannel(channel) {
+		logrus.Warnln("ban: unable to determine current channel")
+		return
+	}
+	if len(args) < 3 {
+		logrus.Warnln("ban: expected a nick or mask")
+		return
+	}
+	for _, target := range args[2:] {
+		srv.resolveBanMask(target, func(mask string, err error) {
+			if err != nil {
+				logrus.Warnln("ban:", err)
+				return
+			}
+			srv.IRCDoAsync(func(conn *irc.Connection) error {
+				conn.Mode(channel, "+b", mask)
+				return nil
+			})
+		})
+	}
+}
+
+func kickbanTarget(srv *Server, args []string) {
+	args = guessTargetInArgs(srv, args, 1)
+	channel := args[1]
+	if !srv.isChannel(channel) {
+		logrus.Warnln("kickban: unable to determine current channel")
+		return
+	}
+	if len(args) < 3 {
+		logrus.Warnln("kickban: expected a nick")
+		return
+	}
+	nick := args[2]
+	msg := strings.Join(args[3:], " ")
+	srv.resolveBanMask(nick, func(mask string, err error) {
+		if err != nil {
+			logrus.Warnln("kickban:", err)
+			return
+		}
+		srv.IRCDoAsync(func(conn *irc.Connection) error {
+			conn.Mode(channel, "+b", mask)
+			conn.Kick(nick, channel, msg)
+			return nil
+		})
+	})
+}
+
+func unbanTarget(srv *Server, args []string) {
+	args = guessTargetInArgs(srv, args, 1)
+	channel := args[1]
+	if !srv.isChannel(channel) {
+		logrus.Warnln("unban: unable to determine current channel")
+		return
+	}
+	if len(args) < 3 {
+		modeListHandler("b")(srv, args[:2])
+		return
+	}
+	unban := func(masks ...string) {
+		srv.IRCDoAsync(func(conn *irc.Connection) error {
+			for _, m := range masks {
+				conn.Mode(channel, "-b", m)
+			}
+			return nil
+		})
+	}
+	var bans []ModeListEntry
+	var cached bool
+	if ch, ok := srv.windows.Named(channel).(*Channel); ok {
+		bans, cached = ch.ModeList("b")
+	}
+	for _, target := range args[2:] {
+		if idx, err := strconv.Atoi(target); err == nil {
+			if !cached {
+				logrus.Warnln("unban: ban list not loaded, run /unban with no arguments to fetch it")
+				return
+			}
+			if idx < 0 || idx >= len(bans) {
+				logrus.Warnf("unban: no ban #%d in %s", idx, channel)
+				continue
+			}
+			unban(bans[idx].Mask)
+		} else if isMask(target) {
+			unban(target)
+		} else {
+			if !cached {
+				logrus.Warnln("unban: ban list not loaded, run /unban with no arguments to fetch it")
+				return
+			}
+			target := target
+			srv.lookupUser(target, func(u UserInfo, ok bool) {
+				if !ok {
+					logrus.Warnln("unban: unable to determine host for", target)
+					return
+				}
+				var masks []string
+				for _, b := range bans {
+					if regexp.MustCompile("(?i)" + globToRegexp(b.Mask)).MatchString(u.Hostmask()) {
+						masks = append(masks, b.Mask)
+					}
+				}
+				if len(masks) == 0 {
+					logrus.Warnf("unban: no bans in %s match %s", channel, u.Hostmask())
+					return
+				}
+				unban(masks...)
+			})
+		}
+	}
+}
+
+func channelSettings(srv *Server, args []string) {
+	win := srv.windows.Active()
+	if win == nil {
+		return
+	}
+	if len(args) < 2 {
+		args = append(args, "list")
+	}
+	network := srv.Network()
+	var rest []string
+	for i := 2; i < len(args); i++ {
+		if args[i] == "-network" && i+1 < len(args) {
+			network = strings.ToLower(args[i+1])
+			i++
+			continue
+		}
+		rest = append(rest, args[i])
+	}
+	if network == "" {
+		logrus.Warnln("channel: unable to determine network, use -network")
+		return
+	}
+	switch args[1] {
+	case "list":
+		WriteChannelConfigs(win, network, srv.channels.List(network))
+	case "add":
+		if len(rest) == 0 || !srv.isChannel(rest[len(rest)-1]) {
+			logrus.Warnln("channel: expected a channel name")
+			return
+		}
+		name := rest[len(rest)-1]
+		c, ok := srv.channels.Get(network, name)
+		if !ok {
+			c = DefaultChannelConfig(name)
+		}
+		opts := rest[:len(rest)-1]
+		for i := 0; i < len(opts); i++ {
+			switch opts[i] {
+			case "-autojoin", "-noautojoin":
+				c.AutoJoin = opts[i] == "-autojoin"
+			case "-rejoin", "-norejoin":
+				c.AutoRejoin = opts[i] == "-rejoin"
+			case "-hidejoins", "-showjoins":
+				c.HideJoins = opts[i] == "-hidejoins"
+			case "-hideparts", "-showparts":
+				c.HideParts = opts[i] == "-hideparts"
+			case "-log", "-nolog":
+				c.Logging = opts[i] == "-log"
+			case "-nokey":
+				c.Key = ""
+			case "-key", "-rejoin-delay", "-highlight":
+				if i+1 >= len(opts) {
+					logrus.Warnf("channel: %s expects a value", opts[i])
+					return
+				}
+				v := opts[i+1]
+				switch opts[i] {
+				case "-key":
+					c.Key = v
+				case "-rejoin-delay":
+					d, err := time.ParseDuration(v)
+					if err != nil {
+						logrus.Warnln("channel: invalid rejoin delay:", err)
+						return
+					}
+					c.RejoinDelay.Duration = d
+				case "-highlight":
+					c.Highlights = strings.FieldsFunc(v, func(r rune) bool {
+						return r == ','
+					})
+				}
+				i++
+			default:
+				logrus.Warnln("channel: unknown option", opts[i])
+				return
+			}
+		}
+		if err := srv.channels.Put(network, c); err != nil {
+			logrus.Warnln("channel: failed to save:", err)
+			return
+		}
+		WriteChannelConfig(win, network, c)
+		if ch, ok := srv.windows.Named(name).(*Channel); ok && network == srv.Network() {
+			srv.applyChannelLogging(ch)
+		}
+	case "remove":
+		if len(rest) == 0 {
+			logrus.Warnln("channel: expected a channel name")
+			return
+		}
+		ok, err := srv.channels.Remove(network, rest[0])
+		if err != nil {
+			logrus.Warnln("channel: failed to save:", err)
+			return
+		} else if !ok {
+			logrus.Warnf("channel: %s is not configured on %s", rest[0], network)
+			return
+		}
+		WriteChannelRemoved(win, network, rest[0])
+		if ch, ok := srv.windows.Named(rest[0]).(*Channel); ok && network == srv.Network() {
+			srv.applyChannelLogging(ch)
+		}
+	default:
+		logrus.Warnln("channel: expected add, remove or list")
+	}
+}
+
+func notifyList(srv *Server, args []string) {
+	win := srv.windows.Active()
+	if win == nil {
+		return
+	}
+	if len(args) < 2 {
+		args = append(args, "list")
+	}
+	network := srv.Network()
+	var nicks []string
+	for i := 2; i < len(args); i++ {
+		if args[i] == "-network" && i+1 < len(args) {
+			network = strings.ToLower(args[i+1])
+			i++
+			continue
+		}
+		if args[i] != "" {
+			nicks = append(nicks, args[i])
+		}
+	}
+	if network == "" {
+		logrus.Warnln("notify: unable to determine network, use -network")
+		return
+	}
+	current := network == srv.Network() && srv.CurrentNick() != ""
+	switch args[1] {
+	case "list":
+		list := srv.notify.List(network)
+		online := make([]bool, len(list))
+		if network == srv.Network() {
+			for i, n := range list {
+				online[i] = srv.notify.IsOnline(n)
+			}
+		}
+		WriteNotifyList(win, network, list, online)
+	case "add", "del":
+		if len(nicks) == 0 {
+			logrus.Warnln("notify: expected at least one nick")
+			return
+		}
+		var changed []string
+		for _, n := range nicks {
+			var ok bool
+			var err error
+			if args[1] == "add" {
+				ok, err = srv.notify.Add(network, n)
+			} else {
+				ok, err = srv.notify.Remove(network, n)
+			}
+			if err != nil {
+				logrus.Warnln("notify: failed to save:", err)
+				return
+			}
+			if !ok {
+				if args[1] == "add" {
+					logrus.Warnf("notify: %s is already in the notify list of %s", n, network)
+				} else {
+					logrus.Warnf("notify: %s is not in the notify list of %s", n, network)
+				}
+				continue
+			}
+			changed = append(changed, n)
+		}
+		if len(changed) == 0 {
+			return
+		}
+		WriteNotifyChanged(win, network, changed, args[1] == "add")
+		if !current {
+			return
+		}
+		switch {
+		case args[1] == "del" && srv.notify.Monitoring():
+			var unwatch []string
+			for _, n := range srv.notify.Unmonitor(changed) {
+				// the preferred nick may still be monitored to regain it
+				if !srv.casemap.Equal(n, srv.nicks.Primary()) {
+					unwatch = append(unwatch, n)
+				}
+			}
+			if len(unwatch) > 0 {
+				srv.monitor("-", unwatch)
+			}
+		case args[1] == "add":
+			srv.watchNotifyNicks(changed)
+		}
+		srv.events.Emit("ui.DIRTY", nil)
+	default:
+		logrus.Warnln("notify: expected add, del or list")
+	}
+}
+
+func tlsInfo(srv *Server, args []string) {
+	win := srv.windows.Active()
+	if win == nil {
+		return
+	}
+	pins := srv.settings.Get().TLSPins
+	info, ok := srv.tlsm.Info(pins)
+	if !ok {
+		logrus.Warnln("tlsinfo: the current connection is not using TLS")
+		return
+	}
+	WriteTLSInfo(win, info, len(pins) > 0)
+}
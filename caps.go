@@ -0,0 +1,175 @@
+package squirssi
+
+import (
+	"strings"
+	"sync"
+
+	"code.dopame.me/veonik/squircy3/irc"
+	"github.com/sirupsen/logrus"
+)
+
+// wantedCaps are the IRCv3 capabilities requested when the server offers them.
+var wantedCaps = []string{
+	"cap-notify",
+	"multi-prefix",
+	"userhost-in-names",
+	"away-notify",
+	"account-notify",
+	"extended-join",
+	"chghost",
+	"setname",
+	"invite-notify",
+	"batch",
+	"server-time",
+	"message-tags",
+	"draft/chathistory",
+	"echo-message",
+	"labeled-response",
+}
+
+// A CapManager tracks the IRCv3 capabilities offered by the server and
+// which of them are enabled on the current connection.
+type CapManager struct {
+	available map[string]string
+	enabled   map[string]struct{}
+
+	mu sync.RWMutex
+}
+
+func NewCapManager() *CapManager {
+	return &CapManager{available: make(map[string]string), enabled: make(map[string]struct{})}
+}
+
+// Reset forgets all capabilities.
+func (cm *CapManager) Reset() {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.available = make(map[string]string)
+	cm.enabled = make(map[string]struct{})
+}
+
+// Offer records capabilities offered by the server in a CAP LS or NEW reply.
+func (cm *CapManager) Offer(caps []string) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	for _, c := range caps {
+		kv := strings.SplitN(c, "=", 2)
+		v := ""
+		if len(kv) > 1 {
+			v = kv[1]
+		}
+		cm.available[kv[0]] = v
+	}
+}
+
+// Withdraw removes capabilities the server no longer offers.
+func (cm *CapManager) Withdraw(caps []string) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	for _, c := range caps {
+		delete(cm.available, c)
+		delete(cm.enabled, c)
+	}
+}
+
+// Acknowledge enables or disables capabilities from a CAP ACK reply.
+func (cm *CapManager) Acknowledge(caps []string) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	for _, c := range caps {
+		if strings.HasPrefix(c, "-") {
+			delete(cm.enabled, c[1:])
+		} else {
+			cm.enabled[c] = struct{}{}
+		}
+	}
+}
+
+// Enabled returns true if the given capability is enabled.
+func (cm *CapManager) Enabled(name string) bool {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	_, ok := cm.enabled[name]
+	return ok
+}
+
+// Value returns the value the server advertised for the given capability.
+func (cm *CapManager) Value(name string) (string, bool) {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	v, ok := cm.available[name]
+	return v, ok
+}
+
+// wanted returns the capabilities that are offered, wanted and not
+// already enabled.
+func (cm *CapManager) wanted() []string {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	var res []string
+	for _, c := range wantedCaps {
+		if _, ok := cm.available[c]; !ok {
+			continue
+		}
+		if _, ok := cm.enabled[c]; ok {
+			continue
+		}
+		res = append(res, c)
+	}
+	return res
+}
+
+// requestRegistrationCaps has go-ircevent request the wanted capabilities
+// while registering, so that they are enabled before anything else is
+// received.
+func requestRegistrationCaps(conn *irc.Connection) {
+	requested := make(map[string]struct{})
+	for _, c := range conn.RequestCaps {
+		requested[c] = struct{}{}
+	}
+	for _, c := range wantedCaps {
+		if _, ok := requested[c]; !ok {
+			conn.RequestCaps = append(conn.RequestCaps, c)
+		}
+	}
+}
+
+// requestCaps requests any wanted capabilities the server offers that are
+// not enabled yet.
+func (srv *Server) requestCaps() {
+	caps := srv.caps.wanted()
+	if len(caps) == 0 {
+		return
+	}
+	srv.IRCDoAsync(func(conn *irc.Connection) error {
+		conn.SendRawf("CAP REQ :%s", strings.Join(caps, " "))
+		return nil
+	})
+}
+
+func onIRCCap(srv *Server, ev *IRCEvent) {
+	if len(ev.Args) < 3 {
+		return
+	}
+	sub := strings.ToUpper(ev.Args[1])
+	caps := strings.Fields(ev.Args[len(ev.Args)-1])
+	// a * before the list means more replies follow
+	more := len(ev.Args) > 3 && ev.Args[2] == "*"
+	switch sub {
+	case "LS":
+		// go-ircevent requests the wanted ones while registering
+		srv.caps.Offer(caps)
+	case "NEW":
+		srv.caps.Offer(caps)
+		if !more {
+			srv.requestCaps()
+		}
+	case "DEL":
+		srv.caps.Withdraw(caps)
+	case "ACK":
+		srv.caps.Acknowledge(caps)
+		logrus.Infoln("cap: enabled", strings.Join(caps, " "))
+	case "NAK":
+		logrus.Warnln("cap: server refused", strings.Join(caps, " "))
+	}
+}
@@ -0,0 +1,113 @@
+package squirssi
+
+import (
+	"strings"
+	"sync"
+)
+
+// A CaseMapping defines which characters are considered equivalent when
+// comparing nicks and channel names, as advertised with CASEMAPPING.
+type CaseMapping string
+
+const (
+	// CaseMappingASCII only folds A-Z.
+	CaseMappingASCII CaseMapping = "ascii"
+	// CaseMappingRFC1459 folds A-Z, and []\^ to {}|~.
+	CaseMappingRFC1459 CaseMapping = "rfc1459"
+	// CaseMappingStrictRFC1459 folds A-Z, and []\ to {}|.
+	CaseMappingStrictRFC1459 CaseMapping = "strict-rfc1459"
+)
+
+// lower returns the lowercase equivalent of c.
+func (cm CaseMapping) lower(c byte) byte {
+	switch {
+	case c >= 'A' && c <= 'Z':
+		return c + 'a' - 'A'
+	case cm == CaseMappingASCII:
+		return c
+	case c >= '[' && c <= ']':
+		// [\] to {|}
+		return c + 'a' - 'A'
+	case c == '^' && cm == CaseMappingRFC1459:
+		return '~'
+	}
+	return c
+}
+
+// Fold returns the canonical lowercase form of s.
+func (cm CaseMapping) Fold(s string) string {
+	switch cm {
+	case CaseMappingASCII, CaseMappingRFC1459, CaseMappingStrictRFC1459:
+	default:
+		// unknown mappings such as rfc7613 are unicode aware
+		return strings.ToLower(s)
+	}
+	var b []byte
+	for i := 0; i < len(s); i++ {
+		if l := cm.lower(s[i]); l != s[i] {
+			if b == nil {
+				b = []byte(s)
+			}
+			b[i] = l
+		}
+	}
+	if b == nil {
+		return s
+	}
+	return string(b)
+}
+
+// Equal returns true if a and b are the same under the CaseMapping.
+func (cm CaseMapping) Equal(a, b string) bool {
+	return cm.Fold(a) == cm.Fold(b)
+}
+
+// A CaseMapper holds the CaseMapping of the current connection.
+type CaseMapper struct {
+	mapping CaseMapping
+
+	mu sync.RWMutex
+}
+
+// NewCaseMapper returns a CaseMapper using rfc1459, the default when the
+// server does not advertise CASEMAPPING.
+func NewCaseMapper() *CaseMapper {
+	return &CaseMapper{mapping: CaseMappingRFC1459}
+}
+
+// Mapping returns the current CaseMapping.
+func (c *CaseMapper) Mapping() CaseMapping {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.mapping
+}
+
+// Set changes the current CaseMapping, returning true if it changed.
+func (c *CaseMapper) Set(cm CaseMapping) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.mapping == cm {
+		return false
+	}
+	c.mapping = cm
+	return true
+}
+
+// Fold returns the canonical lowercase form of s.
+func (c *CaseMapper) Fold(s string) string {
+	return c.Mapping().Fold(s)
+}
+
+// Equal returns true if a and b refer to the same nick or channel.
+func (c *CaseMapper) Equal(a, b string) bool {
+	return c.Mapping().Equal(a, b)
+}
+
+// mentionsMe returns true if the message contains the current nick.
+func (srv *Server) mentionsMe(message string) bool {
+	me := srv.CurrentNick()
+	if me == "" {
+		return false
+	}
+	return strings.Contains(srv.casemap.Fold(message), srv.casemap.Fold(me))
+}
@@ -0,0 +1,209 @@
+package squirssi
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// ModeTypes describes how the server treats each channel mode, as advertised
+// by the CHANMODES and PREFIX ISUPPORT tokens.
+type ModeTypes struct {
+	// List modes always take a parameter and maintain a list of masks.
+	List string
+	// Always modes always take a parameter, such as +k.
+	Always string
+	// OnSet modes only take a parameter when being set, such as +l.
+	OnSet string
+	// Flag modes never take a parameter.
+	Flag string
+
+	// Prefix modes set a user's status in the channel. Each mode
+	// corresponds to the nick prefix at the same index in Prefixes,
+	// ordered from highest to lowest rank.
+	Prefix   string
+	Prefixes string
+}
+
+// DefaultModeTypes are used when the server does not advertise CHANMODES
+// or PREFIX.
+var DefaultModeTypes = ModeTypes{
+	List:     "beI",
+	Always:   "k",
+	OnSet:    "l",
+	Flag:     "imnpst",
+	Prefix:   "ov",
+	Prefixes: "@+",
+}
+
+// modeTypes returns the ModeTypes for the current connection.
+func (srv *Server) modeTypes() ModeTypes {
+	mt := DefaultModeTypes
+	if v, ok := srv.isupport.Value("CHANMODES"); ok {
+		p := strings.Split(v, ",")
+		if len(p) >= 4 {
+			mt.List, mt.Always, mt.OnSet, mt.Flag = p[0], p[1], p[2], p[3]
+		}
+	}
+	if v, ok := srv.isupport.Value("PREFIX"); ok {
+		if v == "" {
+			mt.Prefix, mt.Prefixes = "", ""
+		} else if i := strings.Index(v, ")"); strings.HasPrefix(v, "(") && i > 0 && len(v)-i-1 == i-1 {
+			mt.Prefix, mt.Prefixes = v[1:i], v[i+1:]
+		}
+	}
+	return mt
+}
+
+// PrefixFor returns the nick prefix for the given prefix mode, such as "@" for "o".
+func (mt ModeTypes) PrefixFor(mode byte) (byte, bool) {
+	if i := strings.IndexByte(mt.Prefix, mode); i >= 0 {
+		return mt.Prefixes[i], true
+	}
+	return 0, false
+}
+
+// takesParam returns true if mode takes a parameter when added or removed.
+func (mt ModeTypes) takesParam(mode byte, adding bool) bool {
+	switch {
+	case strings.IndexByte(mt.List, mode) >= 0,
+		strings.IndexByte(mt.Always, mode) >= 0,
+		strings.IndexByte(mt.Prefix, mode) >= 0:
+		return true
+	case strings.IndexByte(mt.OnSet, mode) >= 0:
+		return adding
+	}
+	return false
+}
+
+// A ModeChange is a single mode being added or removed.
+type ModeChange struct {
+	Adding bool
+	Mode   byte
+	Param  string
+}
+
+// ParseModeChanges splits a MODE string and its parameters into individual changes.
+func (mt ModeTypes) ParseModeChanges(modes string, params []string) []ModeChange {
+	var res []ModeChange
+	adding := true
+	for i := 0; i < len(modes); i++ {
+		m := modes[i]
+		switch m {
+		case '+':
+			adding = true
+			continue
+		case '-':
+			adding = false
+			continue
+		}
+		c := ModeChange{Adding: adding, Mode: m}
+		if mt.takesParam(m, adding) && len(params) > 0 {
+			c.Param = params[0]
+			params = params[1:]
+		}
+		res = append(res, c)
+	}
+	return res
+}
+
+// ChannelModes are the non-list, non-prefix modes set on a channel and
+// their parameters.
+type ChannelModes map[byte]string
+
+// String returns the modes in the form "+klnt key 10".
+// If maskKey is true, the channel key is replaced with asterisks.
+func (cm ChannelModes) String(maskKey bool) string {
+	if len(cm) == 0 {
+		return ""
+	}
+	var modes []byte
+	for m := range cm {
+		modes = append(modes, m)
+	}
+	sort.Slice(modes, func(i, j int) bool {
+		return modes[i] < modes[j]
+	})
+	var params []string
+	for _, m := range modes {
+		p := cm[m]
+		if p == "" {
+			continue
+		}
+		if maskKey && m == 'k' {
+			p = strings.Repeat("*", len(p))
+		}
+		params = append(params, p)
+	}
+	return strings.TrimSpace("+" + string(modes) + " " + strings.Join(params, " "))
+}
+
+// applyModeChanges updates ch with the given mode changes.
+func applyModeChanges(ch *Channel, mt ModeTypes, changes []ModeChange, setBy string) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if ch.modes == nil {
+		ch.modes = make(ChannelModes)
+	}
+	ch.users.SetPrefixes(mt.Prefixes)
+	for _, c := range changes {
+		switch {
+		case strings.IndexByte(mt.Prefix, c.Mode) >= 0:
+			prefix, _ := mt.PrefixFor(c.Mode)
+			if u, ok := ch.users.Get(c.Param); ok {
+				ch.users.SetModes(u.string, updatePrefixes(u.modes, prefix, c.Adding, mt.Prefixes))
+			}
+		case strings.IndexByte(mt.List, c.Mode) >= 0:
+			m := string(c.Mode)
+			l, ok := ch.lists[m]
+			if !ok {
+				// only keep lists that have been fetched up to date
+				continue
+			}
+			var res []ModeListEntry
+			for _, e := range l {
+				if !strings.EqualFold(e.Mask, c.Param) {
+					res = append(res, e)
+				}
+			}
+			if c.Adding {
+				res = append(res, ModeListEntry{Mask: c.Param, SetBy: setBy, SetAt: time.Now()})
+			}
+			ch.lists[m] = res
+		case c.Adding:
+			ch.modes[c.Mode] = c.Param
+		default:
+			delete(ch.modes, c.Mode)
+		}
+	}
+}
+
+// updatePrefixes adds or removes prefix from the given nick prefixes,
+// keeping them in order of rank.
+func updatePrefixes(current string, prefix byte, adding bool, ranked string) string {
+	has := strings.IndexByte(current, prefix) >= 0
+	if has == adding {
+		return current
+	}
+	if !adding {
+		return strings.Replace(current, string(prefix), "", 1)
+	}
+	return rankPrefixes(current+string(prefix), ranked)
+}
+
+// rankPrefixes orders the given nick prefixes from highest to lowest rank.
+// Prefixes that do not appear in ranked are kept at the end.
+func rankPrefixes(prefixes string, ranked string) string {
+	var res, rest []byte
+	for i := 0; i < len(ranked); i++ {
+		if strings.IndexByte(prefixes, ranked[i]) >= 0 {
+			res = append(res, ranked[i])
+		}
+	}
+	for i := 0; i < len(prefixes); i++ {
+		if strings.IndexByte(ranked, prefixes[i]) < 0 {
+			rest = append(rest, prefixes[i])
+		}
+	}
+	return string(append(res, rest...))
+}
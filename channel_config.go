@@ -0,0 +1,243 @@
+package squirssi
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// A ChannelConfig contains the preferences for a single channel.
+type ChannelConfig struct {
+	Name string `json:"name"`
+	Key  string `json:"key,omitempty"`
+	// AutoJoin joins the channel after connecting.
+	AutoJoin bool `json:"auto_join"`
+	// AutoRejoin joins the channel again after RejoinDelay when kicked.
+	AutoRejoin  bool     `json:"auto_rejoin"`
+	RejoinDelay Duration `json:"rejoin_delay"`
+	HideJoins   bool     `json:"hide_joins"`
+	HideParts   bool     `json:"hide_parts"`
+	// Logging writes everything in the channel window to a log file.
+	Logging bool `json:"logging"`
+	// Highlights are words that highlight a message like the current nick does.
+	Highlights []string `json:"highlights,omitempty"`
+}
+
+// DefaultChannelConfig returns the preferences used for channels that
+// are not configured.
+func DefaultChannelConfig(name string) ChannelConfig {
+	return ChannelConfig{
+		Name:        name,
+		AutoJoin:    true,
+		AutoRejoin:  true,
+		RejoinDelay: Duration{2 * time.Second},
+	}
+}
+
+// Highlighted returns true if message contains any of the highlight words.
+func (c ChannelConfig) Highlighted(message string) bool {
+	m := strings.ToLower(message)
+	for _, h := range c.Highlights {
+		if h != "" && strings.Contains(m, strings.ToLower(h)) {
+			return true
+		}
+	}
+	return false
+}
+
+const channelsStoreSection = "channels"
+
+// ChannelConfigs contains the configured channels of each network.
+type ChannelConfigs struct {
+	networks map[string][]ChannelConfig
+	store    *Store
+	casemap  *CaseMapper
+
+	mu sync.RWMutex
+}
+
+func NewChannelConfigs(store *Store, casemap *CaseMapper) *ChannelConfigs {
+	return &ChannelConfigs{networks: make(map[string][]ChannelConfig), store: store, casemap: casemap}
+}
+
+// Load restores the channel configuration from the Store.
+func (cc *ChannelConfigs) Load() error {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	networks := make(map[string][]ChannelConfig)
+	if err := cc.store.Load(channelsStoreSection, &networks); err != nil {
+		return err
+	}
+	cc.networks = networks
+	return nil
+}
+
+func (cc *ChannelConfigs) index(network, channel string) int {
+	for i, c := range cc.networks[network] {
+		if cc.casemap.Equal(c.Name, channel) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Get returns the configuration for channel on network.
+func (cc *ChannelConfigs) Get(network, channel string) (ChannelConfig, bool) {
+	cc.mu.RLock()
+	defer cc.mu.RUnlock()
+	if i := cc.index(network, channel); i >= 0 {
+		return cc.networks[network][i], true
+	}
+	return ChannelConfig{}, false
+}
+
+// Put adds or replaces the configuration of a channel on network.
+func (cc *ChannelConfigs) Put(network string, c ChannelConfig) error {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if i := cc.index(network, c.Name); i >= 0 {
+		cc.networks[network][i] = c
+	} else {
+		cc.networks[network] = append(cc.networks[network], c)
+	}
+	return cc.store.Save(channelsStoreSection, cc.networks)
+}
+
+// Remove removes the configuration of a channel on network.
+func (cc *ChannelConfigs) Remove(network, channel string) (bool, error) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	i := cc.index(network, channel)
+	if i < 0 {
+		return false, nil
+	}
+	l := cc.networks[network]
+	cc.networks[network] = append(l[:i:i], l[i+1:]...)
+	if len(cc.networks[network]) == 0 {
+		delete(cc.networks, network)
+	}
+	return true, cc.store.Save(channelsStoreSection, cc.networks)
+}
+
+// List returns the configured channels on network.
+func (cc *ChannelConfigs) List(network string) []ChannelConfig {
+	cc.mu.RLock()
+	defer cc.mu.RUnlock()
+	return append([]ChannelConfig{}, cc.networks[network]...)
+}
+
+// Networks returns the names of networks with configured channels.
+func (cc *ChannelConfigs) Networks() []string {
+	cc.mu.RLock()
+	defer cc.mu.RUnlock()
+	var res []string
+	for n := range cc.networks {
+		res = append(res, n)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Network returns the name of the current network, as advertised with
+// NETWORK or otherwise the address of the server.
+// The last known network is returned while disconnected.
+func (srv *Server) Network() string {
+	srv.mu.RLock()
+	defer srv.mu.RUnlock()
+	return srv.network
+}
+
+func (srv *Server) setNetwork(network string) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.network = strings.ToLower(network)
+}
+
+// channelConfig returns the configuration for channel on the current network.
+func (srv *Server) channelConfig(channel string) ChannelConfig {
+	if c, ok := srv.channels.Get(srv.Network(), channel); ok {
+		return c
+	}
+	return DefaultChannelConfig(channel)
+}
+
+// autoJoin joins the configured channels on the current network.
+func (srv *Server) autoJoin() {
+	var channels, keys []string
+	for _, c := range srv.channels.List(srv.Network()) {
+		if !c.AutoJoin {
+			continue
+		}
+		channels = append(channels, c.Name)
+		keys = append(keys, c.Key)
+	}
+	if len(channels) > 0 {
+		srv.join(channels, keys)
+	}
+}
+
+// rejoinAfterKick joins channel again if configured to.
+func (srv *Server) rejoinAfterKick(channel string) {
+	c := srv.channelConfig(channel)
+	if !c.AutoRejoin {
+		return
+	}
+	go func() {
+		<-time.After(c.RejoinDelay.Duration)
+		srv.join([]string{channel}, nil)
+	}()
+}
+
+var unsafeFilenameChars = regexp.MustCompile(`[^a-zA-Z0-9#&+!._-]`)
+
+// applyChannelLogging opens or closes the log file of ch as configured.
+func (srv *Server) applyChannelLogging(ch *Channel) {
+	c := srv.channelConfig(ch.Title())
+	if !c.Logging || srv.logDir == "" {
+		ch.SetLog(nil)
+		return
+	}
+	if ch.HasLog() {
+		return
+	}
+	network := unsafeFilenameChars.ReplaceAllString(srv.Network(), "_")
+	name := unsafeFilenameChars.ReplaceAllString(srv.casemap.Fold(ch.Title()), "_")
+	f, err := openChatLog(filepath.Join(srv.logDir, network), name+".log")
+	if err != nil {
+		logrus.Warnln("channel: failed to open log:", err)
+		return
+	}
+	ch.SetLog(f)
+}
+
+func openChatLog(dir, name string) (*os.File, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrap(err, "unable to create log directory")
+	}
+	f, err := os.OpenFile(filepath.Join(dir, name), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to open log file")
+	}
+	if _, err := fmt.Fprintf(f, "--- Log opened %s\n", time.Now().Format(time.RFC1123)); err != nil {
+		return nil, errors.Wrap(err, "unable to write log file")
+	}
+	return f, nil
+}
+
+var styleMarkup = regexp.MustCompile(`\[([^\]]*)\]\([a-z0-9:,]+\)`)
+
+// writeLogLine writes a single window line to w without any styles.
+func writeLogLine(w io.Writer, line string) error {
+	line = styleMarkup.ReplaceAllString(line, "$1")
+	_, err := fmt.Fprintf(w, "%s %s\n", time.Now().Format("2006-01-02 15:04:05"), line)
+	return err
+}
@@ -0,0 +1,213 @@
+package squirssi
+
+import (
+	"sort"
+	"strings"
+)
+
+// ChannelUsers is an indexed set of the users in a channel.
+// Users are kept sorted by rank and then by nick as they join, leave and
+// change, so drawing the user list never needs to sort the whole channel.
+// ChannelUsers is not safe for concurrent use, the Channel guards it.
+type ChannelUsers struct {
+	byKey  map[string]User
+	sorted []User
+
+	// prefixes are the nick prefixes supported in the channel, ordered by rank.
+	prefixes string
+	// fold maps a nick to its canonical lookup key.
+	fold func(string) string
+}
+
+func NewChannelUsers(fold func(string) string) *ChannelUsers {
+	if fold == nil {
+		fold = strings.ToLower
+	}
+	return &ChannelUsers{byKey: make(map[string]User), prefixes: knownPrefixes, fold: fold}
+}
+
+// less returns true if a sorts before b.
+func (cu *ChannelUsers) less(a, b User) bool {
+	ra, rb := a.rank(cu.prefixes), b.rank(cu.prefixes)
+	if ra != rb {
+		return ra < rb
+	}
+	ka, kb := cu.fold(a.string), cu.fold(b.string)
+	if ka != kb {
+		return ka < kb
+	}
+	return a.string < b.string
+}
+
+// search returns the position u has or would have in the sorted list.
+func (cu *ChannelUsers) search(u User) int {
+	return sort.Search(len(cu.sorted), func(i int) bool {
+		return !cu.less(cu.sorted[i], u)
+	})
+}
+
+func (cu *ChannelUsers) insert(u User) {
+	cu.byKey[cu.fold(u.string)] = u
+	i := cu.search(u)
+	cu.sorted = append(cu.sorted, User{})
+	copy(cu.sorted[i+1:], cu.sorted[i:])
+	cu.sorted[i] = u
+}
+
+func (cu *ChannelUsers) remove(u User) {
+	delete(cu.byKey, cu.fold(u.string))
+	i := cu.search(u)
+	if i < len(cu.sorted) && cu.sorted[i] == u {
+		cu.sorted = append(cu.sorted[:i], cu.sorted[i+1:]...)
+	}
+}
+
+// Reset replaces all users. Users that were already in the list are still
+// shown as away if they were.
+func (cu *ChannelUsers) Reset(users []User, prefixes string) {
+	prev := cu.byKey
+	cu.prefixes = prefixes
+	cu.byKey = make(map[string]User, len(users))
+	cu.sorted = cu.sorted[:0]
+	for _, u := range users {
+		k := cu.fold(u.string)
+		if _, ok := cu.byKey[k]; ok {
+			continue
+		}
+		if p, ok := prev[k]; ok {
+			u.away = p.away
+		}
+		cu.byKey[k] = u
+		cu.sorted = append(cu.sorted, u)
+	}
+	sort.Slice(cu.sorted, func(i, j int) bool {
+		return cu.less(cu.sorted[i], cu.sorted[j])
+	})
+}
+
+// Reindex rebuilds the index, such as after the fold function changes.
+func (cu *ChannelUsers) Reindex() {
+	cu.Reset(append([]User{}, cu.sorted...), cu.prefixes)
+}
+
+// SetPrefixes changes the supported nick prefixes, re-sorting if necessary.
+func (cu *ChannelUsers) SetPrefixes(prefixes string) {
+	if prefixes == cu.prefixes {
+		return
+	}
+	cu.Reset(append([]User{}, cu.sorted...), prefixes)
+}
+
+// Get returns the user with the given nick.
+func (cu *ChannelUsers) Get(nick string) (User, bool) {
+	u, ok := cu.byKey[cu.fold(nick)]
+	return u, ok
+}
+
+// Add adds u, or updates the prefixes of an existing user with the same nick.
+func (cu *ChannelUsers) Add(u User) {
+	if old, ok := cu.Get(u.string); ok {
+		cu.remove(old)
+	}
+	cu.insert(u)
+}
+
+// Remove removes the user with the given nick.
+func (cu *ChannelUsers) Remove(nick string) bool {
+	u, ok := cu.Get(nick)
+	if ok {
+		cu.remove(u)
+	}
+	return ok
+}
+
+// Rename changes the nick of a user.
+func (cu *ChannelUsers) Rename(nick, newNick string) bool {
+	u, ok := cu.Get(nick)
+	if !ok {
+		return false
+	}
+	cu.remove(u)
+	u.string = newNick
+	cu.insert(u)
+	return true
+}
+
+// SetModes changes the nick prefixes of a user.
+func (cu *ChannelUsers) SetModes(nick, modes string) bool {
+	u, ok := cu.Get(nick)
+	if !ok {
+		return false
+	}
+	cu.remove(u)
+	u.modes = modes
+	cu.insert(u)
+	return true
+}
+
+// SetAway changes whether a user is shown as away.
+func (cu *ChannelUsers) SetAway(nick string, away bool) bool {
+	u, ok := cu.Get(nick)
+	if !ok || u.away == away {
+		return false
+	}
+	// away users keep their position
+	i := cu.search(u)
+	u.away = away
+	cu.byKey[cu.fold(u.string)] = u
+	if i < len(cu.sorted) {
+		cu.sorted[i] = u
+	}
+	return true
+}
+
+// Len returns the number of users.
+func (cu *ChannelUsers) Len() int {
+	return len(cu.sorted)
+}
+
+// Slice returns a copy of the users from start up to end in sorted order.
+func (cu *ChannelUsers) Slice(start, end int) []User {
+	if start < 0 {
+		start = 0
+	}
+	if end > len(cu.sorted) {
+		end = len(cu.sorted)
+	}
+	if start >= end {
+		return nil
+	}
+	return append([]User{}, cu.sorted[start:end]...)
+}
+
+// Nicks returns the nick of every user in sorted order.
+func (cu *ChannelUsers) Nicks() []string {
+	res := make([]string, len(cu.sorted))
+	for i, u := range cu.sorted {
+		res[i] = u.string
+	}
+	return res
+}
+
+// channelUserRows provides the styled rows of a Channel's user list on demand.
+type channelUserRows struct {
+	ch     *Channel
+	styles RankStyles
+}
+
+func (r channelUserRows) Len() int {
+	r.ch.mu.RLock()
+	defer r.ch.mu.RUnlock()
+	return r.ch.users.Len()
+}
+
+func (r channelUserRows) Rows(start, end int) []string {
+	r.ch.mu.RLock()
+	users := r.ch.users.Slice(start, end)
+	r.ch.mu.RUnlock()
+	res := make([]string, len(users))
+	for i, u := range users {
+		res[i] = u.Styled(r.styles)
+	}
+	return res
+}
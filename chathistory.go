@@ -0,0 +1,309 @@
+package squirssi
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"code.dopame.me/veonik/squircy3/irc"
+)
+
+// historyLimit is the most messages requested from chat history at once.
+const historyLimit = 50
+
+// historyTimeout is how long to wait for a chat history request to be
+// answered before another can be made for the same target.
+const historyTimeout = 30 * time.Second
+
+// maxHistoryIDs is how many message IDs are remembered for each target.
+const maxHistoryIDs = 1000
+
+// serverTimeFormat is the format of IRCv3 server-time tags and of the
+// timestamps in CHATHISTORY requests.
+const serverTimeFormat = "2006-01-02T15:04:05.000Z"
+
+// historyTarget is what is known about the history of a channel or query.
+type historyTarget struct {
+	window Window
+	// ids are the IDs of the messages already shown, oldest first.
+	ids  []string
+	seen map[string]struct{}
+
+	// oldest and latest refer to the oldest and newest messages seen, in
+	// the form used in CHATHISTORY requests.
+	oldest   string
+	oldestAt time.Time
+	latest   string
+	latestAt time.Time
+
+	// requested is when the pending request was sent, if any.
+	requested time.Time
+	before    bool
+	// exhausted is true once the server has no older messages.
+	exhausted bool
+}
+
+// historyRef returns how a message is referred to in CHATHISTORY requests.
+func historyRef(msgid string, at time.Time) string {
+	if msgid != "" {
+		return "msgid=" + msgid
+	}
+	return "timestamp=" + at.UTC().Format(serverTimeFormat)
+}
+
+// historyBatch is an open chathistory batch.
+type historyBatch struct {
+	target string
+	count  int
+}
+
+// A ChatHistoryManager keeps track of the messages shown in each window so
+// that those played back with the IRCv3 chathistory extension are not shown
+// twice, and of the requests and batches in progress.
+type ChatHistoryManager struct {
+	targets map[string]*historyTarget
+	batches map[string]*historyBatch
+	casemap *CaseMapper
+
+	mu sync.Mutex
+}
+
+func NewChatHistoryManager(casemap *CaseMapper) *ChatHistoryManager {
+	return &ChatHistoryManager{
+		targets: make(map[string]*historyTarget),
+		batches: make(map[string]*historyBatch),
+		casemap: casemap,
+	}
+}
+
+// Reset forgets pending requests and open batches. Seen messages are kept
+// so that history played back after reconnecting is not shown again.
+func (hm *ChatHistoryManager) Reset() {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+	hm.batches = make(map[string]*historyBatch)
+	for _, t := range hm.targets {
+		t.requested = time.Time{}
+	}
+}
+
+// target returns the history of name as shown in win, starting over if win
+// is not the window it was shown in before. A nil win matches any window.
+// hm.mu must be held.
+func (hm *ChatHistoryManager) target(name string, win Window) *historyTarget {
+	key := hm.casemap.Fold(name)
+	t, ok := hm.targets[key]
+	if !ok || win != nil && t.window != nil && t.window != win {
+		t = &historyTarget{seen: make(map[string]struct{})}
+		hm.targets[key] = t
+	}
+	if win != nil {
+		t.window = win
+	}
+	return t
+}
+
+// Record notes that a message sent to name at the given time is shown in
+// win. It returns false if the message was already shown.
+func (hm *ChatHistoryManager) Record(name string, win Window, msgid string, at time.Time) bool {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+	t := hm.target(name, win)
+	if msgid != "" {
+		if _, ok := t.seen[msgid]; ok {
+			return false
+		}
+		t.seen[msgid] = struct{}{}
+		t.ids = append(t.ids, msgid)
+		if len(t.ids) > maxHistoryIDs {
+			delete(t.seen, t.ids[0])
+			t.ids = t.ids[1:]
+		}
+	}
+	if t.oldest == "" || at.Before(t.oldestAt) {
+		t.oldest, t.oldestAt = historyRef(msgid, at), at
+	}
+	if msgid != "" && (t.latest == "" || !at.Before(t.latestAt)) {
+		t.latest, t.latestAt = historyRef(msgid, at), at
+	}
+	return true
+}
+
+// Request starts a request for the history of name shown in win, either
+// the messages before the oldest seen or the latest. It returns where the
+// request starts from, or false if a request is pending or there is no
+// older history.
+func (hm *ChatHistoryManager) Request(name string, win Window, before bool) (string, bool) {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+	t := hm.target(name, win)
+	if time.Since(t.requested) < historyTimeout || before && t.exhausted {
+		return "", false
+	}
+	t.requested = time.Now()
+	t.before = before
+	if before {
+		if t.oldest == "" {
+			return historyRef("", time.Now()), true
+		}
+		return t.oldest, true
+	}
+	if t.latest == "" {
+		return "*", true
+	}
+	return t.latest, true
+}
+
+// StartBatch records an open chathistory batch for target.
+func (hm *ChatHistoryManager) StartBatch(ref, target string) {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+	hm.batches[ref] = &historyBatch{target: target}
+}
+
+// Batch returns the target of the chathistory batch ev is part of, and
+// counts ev as one of its messages.
+func (hm *ChatHistoryManager) Batch(ev *IRCEvent) (string, bool) {
+	ref, ok := ev.Tags["batch"]
+	if !ok {
+		return "", false
+	}
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+	b, ok := hm.batches[ref]
+	if !ok {
+		return "", false
+	}
+	b.count++
+	return b.target, true
+}
+
+// EndBatch closes a chathistory batch, completing the request for its target.
+func (hm *ChatHistoryManager) EndBatch(ref string) {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+	b, ok := hm.batches[ref]
+	if !ok {
+		return
+	}
+	delete(hm.batches, ref)
+	t := hm.target(b.target, nil)
+	if t.before && b.count == 0 {
+		t.exhausted = true
+	}
+	t.requested = time.Time{}
+}
+
+// historyLimit returns how many messages to request at once, no more than
+// the server allows.
+func (srv *Server) historyLimit() int {
+	limit := historyLimit
+	if v, ok := srv.isupport.Value("CHATHISTORY"); ok {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 && n < limit {
+			limit = n
+		}
+	}
+	return limit
+}
+
+// fetchHistory requests the history of win, either the messages before the
+// oldest shown or the latest, if the server supports chathistory.
+func (srv *Server) fetchHistory(win Window, before bool) {
+	if !srv.caps.Enabled("draft/chathistory") {
+		return
+	}
+	switch win.(type) {
+	case *Channel, *DirectMessage:
+	default:
+		return
+	}
+	target := win.Title()
+	from, ok := srv.chathistory.Request(target, win, before)
+	if !ok {
+		return
+	}
+	sub := "LATEST"
+	if before {
+		sub = "BEFORE"
+	}
+	limit := srv.historyLimit()
+	srv.IRCDoAsync(func(conn *irc.Connection) error {
+		conn.SendRawf("CHATHISTORY %s %s %s %d", sub, target, from, limit)
+		return nil
+	})
+}
+
+// messageTime returns when ev was sent, according to its server-time tag.
+func messageTime(ev *IRCEvent) time.Time {
+	if v, ok := ev.Tags["time"]; ok {
+		if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			return t
+		}
+	}
+	return time.Now()
+}
+
+// messageWindow returns win set up to show the message ev as lines with
+// its ID, quoting the message it replies to, if any. Messages from history
+// are placed in order of time.
+func (srv *Server) messageWindow(win Window, ev *IRCEvent, insert bool) Window {
+	mw := messageWindow{
+		Window: win,
+		info: MessageInfo{
+			ID:     ev.Tags["msgid"],
+			Nick:   ev.Nick,
+			Text:   ev.Message,
+			Action: ev.Code == "CTCP_ACTION",
+		},
+		at:     messageTime(ev),
+		insert: insert,
+	}
+	if id := ev.Tags["+draft/reply"]; id != "" {
+		parent, ok := windowMessage(win, id)
+		mw.quote = replyQuoteLine(win, parent, ok)
+	}
+	return mw
+}
+
+// playback handles a message that was already shown or is part of chat
+// history, returning true if there is nothing more to do with it.
+func (srv *Server) playback(ev *IRCEvent) bool {
+	target, inBatch := srv.chathistory.Batch(ev)
+	if !inBatch {
+		target = ev.Target
+		if srv.isMe(target) {
+			target = ev.Nick
+		}
+	}
+	at := messageTime(ev)
+	win := srv.windows.Named(target)
+	if !srv.chathistory.Record(target, win, ev.Tags["msgid"], at) {
+		return true
+	}
+	if !inBatch {
+		return false
+	}
+	if win == nil || isIgnored(srv, ev, target, IgnoreMsgs) {
+		return true
+	}
+	me := srv.CurrentNick()
+	myNick := MyNick(me)
+	nick := SomeNick(ev.Nick)
+	if srv.isMe(ev.Nick) {
+		nick = myNick
+	}
+	pw := srv.messageWindow(win, ev, true)
+	switch ev.Code {
+	case "CTCP_ACTION":
+		WriteAction(pw, nick, SomeMessage(ev.Message, myNick))
+	case "NOTICE":
+		if nick.me {
+			WriteNotice(pw, SomeTarget(target, me), true, ev.Message)
+		} else {
+			WriteNotice(pw, SomeTarget(ev.Nick, me), false, ev.Message)
+		}
+	default:
+		WritePrivmsg(pw, nick, SomeMessage(ev.Message, myNick))
+	}
+	return true
+}
@@ -44,6 +44,9 @@ func (m *Manager) Start() (err error) {
 		return err
 	}
 	srv.OnInterrupt(m.Stop)
+	if err := srv.OpenStore(filepath.Join(m.RootDir, "squirssi.json")); err != nil {
+		return err
+	}
 	return srv.Start()
 }
 
@@ -0,0 +1,59 @@
+package squirssi
+
+import (
+	"time"
+
+	"code.dopame.me/veonik/squircy3/irc"
+)
+
+// ctcpRequestCodes are the CTCP requests that are answered automatically.
+var ctcpRequestCodes = []string{"CTCP_VERSION", "CTCP_TIME", "CTCP_PING", "CTCP_USERINFO", "CTCP_CLIENTINFO"}
+
+// handleCTCPRequests removes go-ircevent's own replies to CTCP requests,
+// which are sent to anyone who asks, so that onIRCCTCPRequest can leave
+// out ignored users.
+func handleCTCPRequests(conn *irc.Connection) {
+	for _, code := range ctcpRequestCodes {
+		conn.ClearCallback(code)
+	}
+}
+
+// ctcpReply returns the reply to the CTCP request ev, the same as
+// go-ircevent would send.
+func ctcpReply(conn *irc.Connection, ev *IRCEvent) string {
+	switch ev.Code {
+	case "CTCP_VERSION":
+		return "VERSION " + conn.Version
+	case "CTCP_TIME":
+		return "TIME " + time.Now().String()
+	case "CTCP_PING":
+		return ev.Message
+	case "CTCP_USERINFO":
+		return "USERINFO " + conn.RealName
+	case "CTCP_CLIENTINFO":
+		return "CLIENTINFO PING VERSION TIME USERINFO CLIENTINFO"
+	}
+	return ""
+}
+
+func onIRCCTCPRequest(srv *Server, ev *IRCEvent) {
+	if srv.isMe(ev.Nick) || ev.Tags["batch"] != "" {
+		// our own requests echoed back, or played back from history
+		return
+	}
+	channel := ""
+	if srv.isChannel(ev.Target) {
+		channel = ev.Target
+	}
+	if isIgnored(srv, ev, channel, IgnoreCTCPs) {
+		return
+	}
+	srv.IRCDoAsync(func(conn *irc.Connection) error {
+		reply := ctcpReply(conn, ev)
+		if reply == "" {
+			return nil
+		}
+		srv.sendNotice(conn, ev.Nick, "\x01"+reply+"\x01")
+		return nil
+	})
+}
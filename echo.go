@@ -0,0 +1,273 @@
+package squirssi
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"code.dopame.me/veonik/squircy3/irc"
+	"github.com/sirupsen/logrus"
+)
+
+// echoTimeout is how long to wait for the server to echo a message back
+// before showing it as not sent.
+const echoTimeout = 30 * time.Second
+
+// sendErrorCodes are the numerics that mean a message to the target in
+// their first parameter was not delivered.
+var sendErrorCodes = map[string]struct{}{
+	"401": {},
+	"403": {},
+	"404": {},
+	"407": {},
+	"716": {},
+}
+
+// A pendingSend is a message we sent that the server has not echoed yet.
+type pendingSend struct {
+	label   string
+	target  string
+	message string
+	action  bool
+
+	window Window
+	// shown is the message as shown in the window, and token marks the
+	// line it was written as.
+	shown string
+	token string
+}
+
+// A pendingNotice is a notice we sent that the server has not echoed yet.
+// Notices are shown when they are sent, so only the echo is dropped.
+type pendingNotice struct {
+	target  string
+	message string
+	sent    time.Time
+}
+
+// An EchoManager keeps track of messages waiting to be echoed back by the
+// server with the IRCv3 echo-message capability.
+type EchoManager struct {
+	pending []*pendingSend
+	sent    int
+	notices []pendingNotice
+
+	mu sync.Mutex
+}
+
+func NewEchoManager() *EchoManager {
+	return &EchoManager{}
+}
+
+// Reset forgets all pending messages.
+func (em *EchoManager) Reset() {
+	em.mu.Lock()
+	defer em.mu.Unlock()
+	em.pending = nil
+	em.notices = nil
+}
+
+func (em *EchoManager) add(p *pendingSend) {
+	em.mu.Lock()
+	defer em.mu.Unlock()
+	em.sent++
+	p.token = "send" + strconv.Itoa(em.sent)
+	em.pending = append(em.pending, p)
+}
+
+// take removes and returns the oldest pending message that matches.
+func (em *EchoManager) take(match func(p *pendingSend) bool) *pendingSend {
+	em.mu.Lock()
+	defer em.mu.Unlock()
+	for i, p := range em.pending {
+		if match(p) {
+			em.pending = append(em.pending[:i:i], em.pending[i+1:]...)
+			return p
+		}
+	}
+	return nil
+}
+
+func (em *EchoManager) addNotice(target, message string) {
+	em.mu.Lock()
+	defer em.mu.Unlock()
+	now := time.Now()
+	for len(em.notices) > 0 && now.Sub(em.notices[0].sent) > echoTimeout {
+		em.notices = em.notices[1:]
+	}
+	em.notices = append(em.notices, pendingNotice{target: target, message: message, sent: now})
+}
+
+// takeNotice removes the oldest pending notice that matches, returning
+// false if there is none.
+func (em *EchoManager) takeNotice(match func(n pendingNotice) bool) bool {
+	em.mu.Lock()
+	defer em.mu.Unlock()
+	for i, n := range em.notices {
+		if match(n) {
+			em.notices = append(em.notices[:i:i], em.notices[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// sendNotice sends a notice to target. With echo-message, the notice is
+// remembered so that its echo is not shown a second time.
+func (srv *Server) sendNotice(conn *irc.Connection, target, message string) {
+	if srv.caps.Enabled("echo-message") {
+		srv.echoes.addNotice(target, message)
+	}
+	conn.SendRawf("NOTICE %s :%s", target, message)
+}
+
+// sendMessage sends a message or action to target, showing it in win as
+// shown. If parent has an ID, the message is sent as a reply to it. With
+// echo-message, the message is shown as pending until the server echoes
+// it back.
+func (srv *Server) sendMessage(win Window, target, message, shown string, action bool, parent MessageInfo) {
+	text := message
+	if action {
+		text = "\x01ACTION " + message + "\x01"
+	}
+	if parent.ID != "" && !srv.clientTagAllowed("draft/reply") {
+		logrus.Warnln("reply: the server does not allow reply tags, sending as a normal message")
+		parent = MessageInfo{}
+	}
+	tags := make(map[string]string)
+	if label := srv.newLabel(win); label != "" {
+		tags["label"] = label
+	}
+	if parent.ID != "" {
+		tags["+draft/reply"] = parent.ID
+	}
+	label := tags["label"]
+	line := formatTags(tags) + "PRIVMSG " + target + " :" + text
+	srv.typing.Sent(target)
+	me := MyNick(srv.CurrentNick())
+	out := replyTo(win, parent)
+	if !srv.caps.Enabled("echo-message") {
+		if action {
+			WriteAction(out, me, MyMessage(shown))
+		} else {
+			WritePrivmsg(out, me, MyMessage(shown))
+		}
+	} else {
+		p := &pendingSend{
+			label:   label,
+			target:  target,
+			message: message,
+			action:  action,
+			window:  win,
+			shown:   shown,
+		}
+		srv.echoes.add(p)
+		out.token = p.token
+		WriteOwnMessage(out, me, MyMessage(shown), action, SendPending)
+		time.AfterFunc(echoTimeout, func() {
+			srv.failSend(func(o *pendingSend) bool {
+				return o == p
+			})
+		})
+	}
+	srv.IRCDoAsync(func(conn *irc.Connection) error {
+		conn.SendRaw(line)
+		return nil
+	})
+}
+
+// failSend shows the oldest pending message that matches as not sent.
+func (srv *Server) failSend(match func(p *pendingSend) bool) {
+	p := srv.echoes.take(match)
+	if p == nil {
+		return
+	}
+	UpdateOwnMessage(p.window, p.token, MyNick(srv.CurrentNick()), MyMessage(p.shown), p.action, SendFailed)
+}
+
+// failSendFor marks the message an error reply refers to as not sent.
+func (srv *Server) failSendFor(ev *IRCEvent) {
+	if label := srv.labels.Label(ev); label != "" {
+		srv.failSend(func(p *pendingSend) bool {
+			return p.label == label
+		})
+		return
+	}
+	if _, ok := sendErrorCodes[ev.Code]; !ok || len(ev.Args) < 2 {
+		return
+	}
+	target := ev.Args[1]
+	srv.failSend(func(p *pendingSend) bool {
+		return p.label == "" && srv.casemap.Equal(p.target, target)
+	})
+}
+
+// confirmSend shows the message echoed back by the server as sent.
+// It returns false if the echo does not match a pending message.
+func (srv *Server) confirmSend(ev *IRCEvent, action bool) bool {
+	label := ev.Tags["label"]
+	p := srv.echoes.take(func(p *pendingSend) bool {
+		if label != "" {
+			return p.label == label
+		}
+		return p.action == action && p.message == ev.Message && srv.casemap.Equal(p.target, ev.Target)
+	})
+	if p == nil {
+		return false
+	}
+	srv.labelReplied(ev)
+	me := MyNick(srv.CurrentNick())
+	UpdateOwnMessage(p.window, p.token, me, MyMessage(p.shown), p.action, SendConfirmed)
+	info := MessageInfo{ID: ev.Tags["msgid"], Nick: me.string, Text: p.message, Action: p.action}
+	identifyMessage(p.window, p.token, info)
+	return true
+}
+
+// onIRCEcho handles a message we sent that was echoed back by the server.
+func onIRCEcho(srv *Server, ev *IRCEvent, action bool) {
+	if srv.confirmSend(ev, action) {
+		return
+	}
+	// sent by another client or without squirssi's help
+	target := ev.Target
+	message := ev.Message
+	win := srv.windows.Named(target)
+	if win == nil {
+		if srv.isChannel(target) {
+			win = srv.windows.Index(0)
+			message = target + " -> " + message
+		} else {
+			dm := &DirectMessage{newBufferedWindow(target, srv.events)}
+			srv.windows.Append(dm)
+			win = dm
+		}
+	}
+	me := MyNick(srv.CurrentNick())
+	out := srv.messageWindow(win, ev, false)
+	if action {
+		WriteAction(out, me, MyMessage(message))
+	} else {
+		WritePrivmsg(out, me, MyMessage(message))
+	}
+}
+
+// onIRCEchoNotice handles a notice we sent that was echoed back by the server.
+func onIRCEchoNotice(srv *Server, ev *IRCEvent) {
+	if srv.echoes.takeNotice(func(n pendingNotice) bool {
+		return n.message == ev.Message && srv.casemap.Equal(n.target, ev.Target)
+	}) {
+		return
+	}
+	// sent by another client or without squirssi's help
+	win := srv.windows.Named(ev.Target)
+	if win == nil {
+		win = srv.windows.Index(0)
+	}
+	target := SomeTarget(ev.Target, srv.CurrentNick())
+	if strings.Contains(ev.Message, "\x01") {
+		WriteCTCP(win, target, true, ev.Message)
+		return
+	}
+	WriteNotice(win, target, true, ev.Message)
+}
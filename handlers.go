@@ -31,9 +31,15 @@ func onUIDirty(srv *Server, _ *event.Event) {
 // ui.KEYPRESS event is emitted. This is done to avoid extra lag between
 // pressing a key and seeing the UI react.
 func onUIKeyPress(srv *Server, key string) {
+	if srv.away.Input() {
+		// any input brings the user back from auto-away
+		srv.setAway("", false)
+	}
 	if key != "<Tab>" {
 		srv.tabber.Clear()
 	}
+	defer updateChannelListFilter(srv)
+	defer srv.updateTyping()
 	switch key {
 	case "<C-c>":
 		srv.inputTextBox.Append(string(rune(0x03)))
@@ -61,6 +67,10 @@ func onUIKeyPress(srv *Server, key string) {
 		h := srv.pageSize - 2
 		srv.mu.RUnlock()
 		srv.windows.ScrollOffset(-h)
+		if win := srv.windows.Active(); win != nil && win.CurrentLine() == 0 {
+			// scrolled to the top, fetch older messages if possible
+			srv.fetchHistory(win, true)
+		}
 	case "<PageDown>":
 		srv.mu.RLock()
 		h := srv.pageSize - 2
@@ -99,6 +109,10 @@ func onUIKeyPress(srv *Server, key string) {
 		if win == nil {
 			return
 		}
+		if _, ok := win.(*ChannelList); ok {
+			srv.windows.ScrollOffset(-1)
+			return
+		}
 		cur := srv.inputTextBox.Consume()
 		if cur.Text != "" {
 			srv.history.Insert(win, cur)
@@ -111,6 +125,10 @@ func onUIKeyPress(srv *Server, key string) {
 		if win == nil {
 			return
 		}
+		if _, ok := win.(*ChannelList); ok {
+			srv.windows.ScrollOffset(1)
+			return
+		}
 		cur := srv.inputTextBox.Consume()
 		if cur.Text != "" {
 			srv.history.Insert(win, cur)
@@ -130,6 +148,15 @@ func onUIKeyPress(srv *Server, key string) {
 			var tabbed string
 			if srv.tabber.Active() {
 				tabbed = srv.tabber.Tab()
+			} else if srv.inputTextBox.Mode() == widget.ModeCommand {
+				in := srv.inputTextBox.Peek()
+				if topic, ok := topicCompletion(in, ch, srv.casemap); ok {
+					tabbed = srv.tabber.ResetCandidates(in, []string{topic}, false)
+				} else if masks, ok := modeListCompletions(in, ch); ok {
+					tabbed = srv.tabber.ResetCandidates(in, masks, false)
+				} else {
+					tabbed = srv.tabber.Reset(in, ch)
+				}
 			} else {
 				tabbed = srv.tabber.Reset(srv.inputTextBox.Peek(), ch)
 			}
@@ -138,11 +165,18 @@ func onUIKeyPress(srv *Server, key string) {
 		srv.RenderOnly(InputTextBox)
 	case "<Enter>":
 		in := srv.inputTextBox.Consume()
-		active := srv.windows.ActiveIndex()
 		channel := srv.windows.Active()
 		if channel == nil {
 			return
 		}
+		if cl, ok := channel.(*ChannelList); ok && in.Kind == widget.ModeMessage {
+			// join the selected channel in the list
+			if e, ok := cl.Selected(); ok {
+				joinChannel(srv, []string{"join", e.Name})
+			}
+			srv.RenderOnly(InputTextBox)
+			return
+		}
 		if len(in.Text) == 0 {
 			// render anyway incase the textbox mode was changed
 			srv.RenderOnly(MainWindow, InputTextBox)
@@ -160,8 +194,10 @@ func onUIKeyPress(srv *Server, key string) {
 				logrus.Warnln("no command named:", c)
 			}
 		case widget.ModeMessage:
-			if active == 0 {
-				// status window doesn't accept messages
+			switch channel.(type) {
+			case *Channel, *DirectMessage:
+			default:
+				// status and other special windows don't accept messages
 				return
 			}
 			msgTarget(srv, []string{"msg", channel.Title(), in.Text})
@@ -180,3 +216,49 @@ func onUIKeyPress(srv *Server, key string) {
 		srv.RenderOnly(InputTextBox)
 	}
 }
+
+// updateChannelListFilter filters the active ChannelList using the
+// current contents of the input box.
+func updateChannelListFilter(srv *Server) {
+	cl, ok := srv.windows.Active().(*ChannelList)
+	if !ok || srv.inputTextBox.Mode() != widget.ModeMessage {
+		return
+	}
+	if f := srv.inputTextBox.Peek(); f != cl.Filter() {
+		cl.SetFilter(f)
+		srv.events.Emit("ui.DIRTY", nil)
+	}
+}
+
+// modeListCompletions returns the cached list mode masks that can complete
+// the given command input, such as "unban " or "mode -e ".
+func modeListCompletions(input string, ch *Channel) ([]string, bool) {
+	args := strings.Split(input, " ")
+	if len(args) < 2 {
+		return nil, false
+	}
+	mode := ""
+	if args[0] == "unban" {
+		mode = "b"
+	} else if args[0] == "mode" {
+		// use the last mode change before the word being completed
+		for i := len(args) - 2; i > 0; i-- {
+			if strings.HasPrefix(args[i], "-") && len(args[i]) > 1 {
+				mode = args[i][len(args[i])-1:]
+				break
+			}
+		}
+	}
+	if !strings.Contains("beIq", mode) || mode == "" {
+		return nil, false
+	}
+	entries, ok := ch.ModeList(mode)
+	if !ok {
+		return nil, false
+	}
+	res := make([]string, len(entries))
+	for i, e := range entries {
+		res[i] = e.Mask
+	}
+	return res, true
+}
@@ -1,6 +1,7 @@
 package squirssi
 
 import (
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -12,11 +13,15 @@ import (
 )
 
 func bindIRCHandlers(srv *Server, events *event.Dispatcher) {
+	events.Bind("irc.CONNECTING", HandleIRCEvent(srv, onIRCConnecting))
 	events.Bind("irc.CONNECT", HandleIRCEvent(srv, onIRCConnect))
 	events.Bind("irc.DISCONNECT", HandleIRCEvent(srv, onIRCDisconnect))
 	events.Bind("irc.PRIVMSG", HandleIRCEvent(srv, onIRCPrivmsg))
 	events.Bind("irc.NOTICE", HandleIRCEvent(srv, onIRCNotice))
 	events.Bind("irc.CTCP_ACTION", HandleIRCEvent(srv, onIRCAction))
+	for _, code := range ctcpRequestCodes {
+		events.Bind("irc."+code, HandleIRCEvent(srv, onIRCCTCPRequest))
+	}
 	events.Bind("irc.JOIN", HandleIRCEvent(srv, onIRCJoin))
 	events.Bind("irc.PART", HandleIRCEvent(srv, onIRCPart))
 	events.Bind("irc.KICK", HandleIRCEvent(srv, onIRCKick))
@@ -25,31 +30,64 @@ func bindIRCHandlers(srv *Server, events *event.Dispatcher) {
 	events.Bind("irc.KICK", HandleIRCEvent(srv, onIRCNames))
 	events.Bind("irc.NICK", HandleIRCEvent(srv, onIRCNick))
 	events.Bind("irc.433", HandleIRCEvent(srv, onIRC433))
+	events.Bind("irc.437", HandleIRCEvent(srv, onIRC433))
 	events.Bind("irc.353", HandleIRCEvent(srv, onIRC353))
 	events.Bind("irc.366", HandleIRCEvent(srv, onIRC366))
 	events.Bind("irc.QUIT", HandleIRCEvent(srv, onIRCQuit))
 	events.Bind("irc.MODE", HandleIRCEvent(srv, onIRCMode))
+	events.Bind("irc.CAP", HandleIRCEvent(srv, onIRCCap))
+	events.Bind("irc.BATCH", HandleIRCEvent(srv, onIRCBatch))
+	events.Bind("irc.ACK", HandleIRCEvent(srv, onIRCAck))
+	events.Bind("irc.AWAY", HandleIRCEvent(srv, onIRCAway))
+	events.Bind("irc.ACCOUNT", HandleIRCEvent(srv, onIRCAccount))
+	events.Bind("irc.CHGHOST", HandleIRCEvent(srv, onIRCChghost))
+	events.Bind("irc.SETNAME", HandleIRCEvent(srv, onIRCSetname))
+	events.Bind("irc.INVITE", HandleIRCEvent(srv, onIRCInvite))
+	events.Bind("irc.TAGMSG", HandleIRCEvent(srv, onIRCTagmsg))
 	events.Bind("irc.324", HandleIRCEvent(srv, onIRC324))
+	events.Bind("irc.333", HandleIRCEvent(srv, onIRC333))
 	events.Bind("irc.332", HandleIRCEvent(srv, onIRC332))
 	events.Bind("irc.331", HandleIRCEvent(srv, onIRC331))
 	events.Bind("irc.TOPIC", HandleIRCEvent(srv, onIRCTopic))
-	errorCodes := []string{"irc.401", "irc.403", "irc.404", "irc.405", "irc.406", "irc.407", "irc.408", "irc.421"}
-	for _, code := range errorCodes {
-		events.Bind(code, HandleIRCEvent(srv, onIRCError))
-	}
-	whoisCodes := []string{"irc.311", "irc.312", "irc.313", "irc.317", "irc.318", "irc.319", "irc.314", "irc.369"}
+	events.Bind("irc.301", HandleIRCEvent(srv, onIRC301))
+	events.Bind("irc.305", HandleIRCEvent(srv, onIRC305))
+	events.Bind("irc.306", HandleIRCEvent(srv, onIRC306))
+	events.Bind("irc.322", HandleIRCEvent(srv, onIRC322))
+	events.Bind("irc.323", HandleIRCEvent(srv, onIRC323))
+	events.Bind("irc.005", HandleIRCEvent(srv, onIRC005))
+	events.Bind("irc.352", HandleIRCEvent(srv, onIRC352))
+	events.Bind("irc.354", HandleIRCEvent(srv, onIRC354))
+	events.Bind("irc.315", HandleIRCEvent(srv, onIRC315))
+	events.Bind("irc.302", HandleIRCEvent(srv, onIRC302))
+	for _, code := range []string{"irc.367", "irc.348", "irc.346", "irc.728"} {
+		events.Bind(code, HandleIRCEvent(srv, onIRCModeListEntry))
+	}
+	for _, code := range []string{"irc.368", "irc.349", "irc.347", "irc.729"} {
+		events.Bind(code, HandleIRCEvent(srv, onIRCModeListEnd))
+	}
+	whoisCodes := []string{"irc.311", "irc.312", "irc.313", "irc.317", "irc.319", "irc.330", "irc.671"}
 	for _, code := range whoisCodes {
 		events.Bind(code, HandleIRCEvent(srv, onIRCWhois))
 	}
-	miscCodes := []string{
-		"irc.001", "irc.002", "irc.003", "irc.250", "irc.251",
-		"irc.252", "irc.253", "irc.254", "irc.255", "irc.265",
-		"irc.266", "irc.375", "irc.372", "irc.376",
-	}
-	for _, code := range miscCodes {
-		events.Bind(code, HandleIRCEvent(srv, onIRCMessage))
-	}
-	events.Bind("debug.IRC", event.HandlerFunc(handleIRCDebugEvent))
+	events.Bind("irc.318", HandleIRCEvent(srv, onIRC318))
+	events.Bind("irc.314", HandleIRCEvent(srv, onIRCWhowas))
+	events.Bind("irc.369", HandleIRCEvent(srv, onIRC369))
+	for code := range numerics {
+		events.Bind("irc."+code, HandleIRCEvent(srv, onIRCNumeric))
+	}
+	// registration is complete after the MOTD
+	events.Bind("irc.376", HandleIRCEvent(srv, onIRCRegistered))
+	events.Bind("irc.422", HandleIRCEvent(srv, onIRCRegistered))
+	events.Bind("irc.303", HandleIRCEvent(srv, onIRC303))
+	for _, code := range []string{"irc.471", "irc.473", "irc.474", "irc.475", "irc.477"} {
+		events.Bind(code, HandleIRCEvent(srv, onIRCJoinError))
+	}
+	events.Bind("irc.730", HandleIRCEvent(srv, onIRC730))
+	events.Bind("irc.731", HandleIRCEvent(srv, onIRC731))
+	events.Bind("irc.734", HandleIRCEvent(srv, onIRC734))
+	events.Bind("debug.IRC", event.HandlerFunc(func(ev *event.Event) {
+		handleIRCDebugEvent(srv, ev)
+	}))
 }
 
 type IRCEvent struct {
@@ -62,6 +100,8 @@ type IRCEvent struct {
 	Target  string
 	Message string
 	Args    []string
+	// Tags are the IRCv3 message tags sent with the event.
+	Tags map[string]string
 }
 
 func normalizeDebugEvent(ev *event.Event) *IRCEvent {
@@ -82,6 +122,7 @@ func normalizeDebugEvent(ev *event.Event) *IRCEvent {
 		Target:  v.Arguments[0],
 		Message: v.Message(),
 		Args:    v.Arguments,
+		Tags:    v.Tags,
 	}
 }
 
@@ -103,23 +144,24 @@ var debugIgnore = map[string]struct{}{
 	"JOIN":    {},
 	"PART":    {},
 
+	"AUTHENTICATE": {},
+	"BATCH":        {},
+	"ACK":          {},
+	"AWAY":         {},
+	"ACCOUNT":      {},
+	"CHGHOST":      {},
+	"SETNAME":      {},
+	"INVITE":       {},
+	"TAGMSG":       {},
+
 	"366": {},
 	"353": {},
+	"CAP": {},
 	"324": {},
+	"333": {},
 	"329": {},
 	"331": {},
 	"332": {},
-	"001": {},
-	"002": {},
-	"003": {},
-	"251": {},
-	"252": {},
-	"253": {},
-	"254": {},
-	"255": {},
-	"250": {},
-	"265": {},
-	"266": {},
 	"311": {},
 	"312": {},
 	"313": {},
@@ -127,17 +169,50 @@ var debugIgnore = map[string]struct{}{
 	"318": {},
 	"319": {},
 	"369": {},
-	"375": {},
-	"372": {},
-	"376": {},
-	"433": {},
+	"314": {},
+	"330": {},
+	"671": {},
+	"303": {},
+	"471": {},
+	"473": {},
+	"474": {},
+	"475": {},
+	"477": {},
+	"731": {},
+	"730": {},
+	"301": {},
+	"305": {},
+	"306": {},
+	"321": {},
+	"322": {},
+	"323": {},
+	"005": {},
+	"352": {},
+	"354": {},
+	"315": {},
+	"302": {},
+	"367": {},
+	"368": {},
+	"348": {},
+	"349": {},
+	"346": {},
+	"347": {},
+	"728": {},
+	"729": {},
 }
 
-func handleIRCDebugEvent(ev *event.Event) {
+func handleIRCDebugEvent(srv *Server, ev *event.Event) {
 	nev := normalizeDebugEvent(ev)
+	if nev == nil {
+		return
+	}
 	if _, ok := debugIgnore[nev.Code]; ok {
 		return
 	}
+	if _, ok := numerics[nev.Code]; ok {
+		return
+	}
+	onIRCUnknownNumeric(srv, nev)
 	logrus.Debugf("irc.%s - T(%s) N(%s) => %s", nev.Code, nev.Target, nev.Nick, strings.Join(nev.Args[1:], " "))
 }
 
@@ -145,7 +220,7 @@ func NormalizeIRCEvent(ev *event.Event) *IRCEvent {
 	if ev.Data == nil {
 		return nil
 	}
-	return &IRCEvent{
+	res := &IRCEvent{
 		Code:    ev.Data["Code"].(string),
 		Raw:     ev.Data["Raw"].(string),
 		Nick:    ev.Data["Nick"].(string),
@@ -156,6 +231,12 @@ func NormalizeIRCEvent(ev *event.Event) *IRCEvent {
 		Message: ev.Data["Message"].(string),
 		Args:    ev.Data["Args"].([]string),
 	}
+	if tags, ok := ev.Data["Tags"].(map[string]string); ok {
+		res.Tags = tags
+	} else {
+		res.Tags = parseTags(res.Raw)
+	}
+	return res
 }
 
 type IRCEventHandler func(srv *Server, ev *IRCEvent)
@@ -167,13 +248,25 @@ func HandleIRCEvent(srv *Server, h IRCEventHandler) event.Handler {
 	})
 }
 
+// isIgnored returns true if the sender of ev is ignored in channel for the given level.
+func isIgnored(srv *Server, ev *IRCEvent, channel string, level IgnoreLevel) bool {
+	if ev.Nick == "" || srv.isMe(ev.Nick) {
+		return false
+	}
+	return srv.ignores.Ignored(ev.Nick+"!"+ev.User+"@"+ev.Host, channel, level)
+}
+
 func onIRC324(srv *Server, ev *IRCEvent) {
-	modes := strings.Join(ev.Args[2:], " ")
+	if len(ev.Args) < 3 {
+		return
+	}
 	win := srv.windows.Named(ev.Args[1])
 	if ch, ok := win.(*Channel); ok {
 		ch.mu.Lock()
-		ch.modes = modes
+		ch.modes = make(ChannelModes)
 		ch.mu.Unlock()
+		mt := srv.modeTypes()
+		applyModeChanges(ch, mt, mt.ParseModeChanges(ev.Args[2], ev.Args[3:]), "")
 	}
 }
 
@@ -181,9 +274,7 @@ func onIRC331(srv *Server, ev *IRCEvent) {
 	target := ev.Args[1]
 	win := srv.windows.Named(target)
 	if ch, ok := win.(*Channel); ok {
-		ch.mu.Lock()
-		ch.topic = ""
-		ch.mu.Unlock()
+		srv.setTopic(ch, TopicEntry{})
 		Write331(win)
 	}
 }
@@ -193,16 +284,50 @@ func onIRC332(srv *Server, ev *IRCEvent) {
 	win := srv.windows.Named(target)
 	if ch, ok := win.(*Channel); ok {
 		topic := strings.Join(ev.Args[2:], " ")
-		ch.mu.Lock()
-		ch.topic = topic
-		ch.mu.Unlock()
+		srv.setTopic(ch, TopicEntry{Topic: topic})
 		Write332(win, topic)
 	}
 }
 
+func onIRC333(srv *Server, ev *IRCEvent) {
+	if len(ev.Args) < 4 {
+		return
+	}
+	win := srv.windows.Named(ev.Args[1])
+	if ch, ok := win.(*Channel); ok {
+		ts, _ := strconv.ParseInt(ev.Args[3], 10, 64)
+		setBy, setAt := ev.Args[2], time.Unix(ts, 0)
+		srv.setTopic(ch, TopicEntry{Topic: ch.Topic(), SetBy: setBy, SetAt: setAt})
+		Write333(win, setBy, setAt)
+	}
+}
+
+// onIRCConnecting sets up a new connection before squircy3 dials it, so
+// that registration is already done the way squirssi needs. go-ircevent
+// keeps these settings when it reconnects.
+func onIRCConnecting(srv *Server, _ *IRCEvent) {
+	err := srv.irc.Do(func(conn *irc.Connection) error {
+		srv.configureTLS(conn)
+		srv.configureSASL(conn)
+		requestRegistrationCaps(conn)
+		handleNickCollisions(conn)
+		handleCTCPRequests(conn)
+		return nil
+	})
+	if err != nil {
+		logrus.Errorln("irc: unable to set up the connection:", err)
+	}
+}
+
 func onIRCConnect(srv *Server, _ *IRCEvent) {
 	srv.IRCDoAsync(func(conn *irc.Connection) error {
+		if !srv.checkTLS(conn) {
+			return nil
+		}
 		srv.setCurrentNick(conn.GetNick())
+		if srv.nicks.Primary() == "" {
+			srv.nicks.SetPrimary(conn.GetNick())
+		}
 		conn.AddCallback("*", func(ev *irc2.Event) {
 			srv.events.Emit("debug.IRC", map[string]interface{}{
 				"source": ev,
@@ -215,26 +340,40 @@ func onIRCConnect(srv *Server, _ *IRCEvent) {
 func onIRCDisconnect(srv *Server, _ *IRCEvent) {
 	logrus.Infoln("*** Disconnected")
 	srv.setCurrentNick("")
+	srv.setRegistered(false)
+	srv.isupport.Reset()
+	srv.caps.Reset()
+	srv.nicks.Reset()
+	srv.users.Reset()
+	srv.whos.Reset()
+	srv.whoises.Reset()
+	srv.userhosts.Reset()
+	srv.modeLists.Reset()
+	srv.notify.Reset()
+	srv.isons.Reset()
+	srv.echoes.Reset()
+	srv.labels.Reset()
+	srv.tlsm.Reset()
+	srv.chathistory.Reset()
+	srv.typing.Reset()
 }
 
 func onIRCMode(srv *Server, ev *IRCEvent) {
 	target := ev.Target
 	nick := SomeNick(ev.Nick)
 	mode := strings.Join(ev.Args[1:], " ")
-	currNick := srv.CurrentNick()
-	if ev.Nick == currNick {
+	if srv.isMe(ev.Nick) {
 		nick.me = true
-	} else if target == currNick {
+	} else if srv.isMe(target) {
 		nick = MyNick(target)
 	}
 	win := srv.windows.Named(target)
 	if win != nil {
+		if ch, ok := win.(*Channel); ok && len(ev.Args) > 1 {
+			mt := srv.modeTypes()
+			applyModeChanges(ch, mt, mt.ParseModeChanges(ev.Args[1], ev.Args[2:]), ev.Source)
+		}
 		WriteMode(win, nick, mode)
-		onIRCNames(srv, ev)
-		srv.IRCDoAsync(func(conn *irc.Connection) error {
-			conn.Mode(target)
-			return nil
-		})
 	} else {
 		WriteMode(srv.windows.Index(0), nick, mode)
 	}
@@ -244,10 +383,13 @@ func onIRCTopic(srv *Server, ev *IRCEvent) {
 	target := ev.Target
 	nick := SomeNick(ev.Nick)
 	topic := strings.Join(ev.Args[1:], " ")
-	if ev.Nick == srv.CurrentNick() {
+	if srv.isMe(ev.Nick) {
 		nick.me = true
 	}
 	win := srv.windows.Named(target)
+	if ch, ok := win.(*Channel); ok {
+		srv.setTopic(ch, TopicEntry{Topic: topic, SetBy: ev.Nick, SetAt: time.Now()})
+	}
 	if win != nil {
 		WriteTopic(win, nick, topic)
 	} else {
@@ -255,20 +397,32 @@ func onIRCTopic(srv *Server, ev *IRCEvent) {
 	}
 }
 
-func onIRC433(srv *Server, _ *IRCEvent) {
-	srv.IRCDoAsync(func(conn *irc.Connection) error {
-		srv.setCurrentNick(conn.GetNick())
-		return nil
-	})
-}
-
 func onIRCNick(srv *Server, ev *IRCEvent) {
 	nick := SomeNick(ev.Nick)
 	newNick := SomeNick(ev.Message)
-	if ev.Nick == srv.CurrentNick() {
+	srv.users.Rename(nick.string, newNick.string)
+	srv.whoises.Rename(nick.string, newNick.string)
+	if srv.isMe(ev.Nick) {
 		nick.me = true
 		newNick.me = true
 		srv.setCurrentNick(newNick.string)
+	} else {
+		// silently track the nick change where it is ignored
+		global := isIgnored(srv, ev, "", IgnoreNicks)
+		for _, win := range srv.windows.Windows() {
+			switch w := win.(type) {
+			case *Channel:
+				if isIgnored(srv, ev, w.Title(), IgnoreNicks) {
+					w.UpdateUser(nick.string, newNick.string)
+				}
+			case *DirectMessage:
+				if global && srv.casemap.Equal(w.Title(), nick.string) {
+					w.mu.Lock()
+					w.name = newNick.string
+					w.mu.Unlock()
+				}
+			}
+		}
 	}
 	WriteNick(srv.windows, nick, newNick)
 }
@@ -277,23 +431,14 @@ func onIRCKick(srv *Server, ev *IRCEvent) {
 	channel := ev.Target
 	kicker := SomeNick(ev.Nick)
 	kicked := SomeNick(ev.Args[1])
-	myNick := srv.CurrentNick()
-	if kicked.string == myNick {
+	if srv.isMe(kicked.string) {
 		kicked.me = true
 	}
-	if kicker.string == myNick {
+	if srv.isMe(kicker.string) {
 		kicker.me = true
 	}
 	if kicked.me {
-		go func() {
-			<-time.After(2 * time.Second)
-			if err := srv.irc.Do(func(conn *irc.Connection) error {
-				conn.Join(channel)
-				return nil
-			}); err != nil {
-				logrus.Warnln("failed to rejoin after kick:", err)
-			}
-		}()
+		srv.rejoinAfterKick(channel)
 	}
 	win := srv.windows.Named(channel)
 	if win == nil {
@@ -303,23 +448,33 @@ func onIRCKick(srv *Server, ev *IRCEvent) {
 	if ch, ok := win.(*Channel); ok {
 		ch.DeleteUser(kicked.string)
 	}
+	srv.forgetUnlessShared(kicked.string)
 	WriteKick(win, kicker, kicked, ev.Message)
 }
 
 var namesCache = &struct {
 	sync.Mutex
 	values map[string][]string
-}{values: make(map[string][]string)}
+	// requested holds when /names was used for each channel, by folded name.
+	requested map[string]time.Time
+}{values: make(map[string][]string), requested: make(map[string]time.Time)}
 
 func onIRC353(srv *Server, ev *IRCEvent) {
 	// NAMES
 	chanName := ev.Args[2]
-	nicks := strings.Split(ev.Args[3], " ")
+	entries := strings.Fields(ev.Args[3])
 	win := srv.windows.Named(chanName)
 	if win == nil {
 		logrus.Warnln("received NAMES for channel with no window:", chanName)
 		return
 	}
+	prefixes := srv.modeTypes().Prefixes
+	nicks := make([]string, len(entries))
+	for i, e := range entries {
+		nick, user, host := parseNamesEntry(e)
+		nicks[i] = nick
+		srv.users.Seen(ParseUser(nick, prefixes).string, user, host)
+	}
 	namesCache.Lock()
 	defer namesCache.Unlock()
 	namesCache.values[chanName] = append(namesCache.values[chanName], nicks...)
@@ -340,44 +495,23 @@ func onIRC366(srv *Server, ev *IRCEvent) {
 	}
 	namesCache.Lock()
 	defer namesCache.Unlock()
-	ch.SetUsers(namesCache.values[chanName])
+	ch.SetUsers(namesCache.values[chanName], srv.modeTypes().Prefixes)
 	delete(namesCache.values, chanName)
-	srv.windows.events.Emit("ui.DIRTY", nil)
-}
-
-func onIRCError(srv *Server, ev *IRCEvent) {
-	var kind string
-	if len(ev.Args) > 1 {
-		kind = ev.Args[1]
-	} else {
-		kind = ev.Target
+	if srv.namesRequested(chanName) {
+		WriteNames(ch, ch.UserEntries(), srv.modeTypes().Prefixes, srv.chatWidth(ch.padding()), srv.settings.Get().RankStyles)
 	}
-	win := srv.windows.Named(kind)
-	WriteError(win, kind, ev.Message)
-}
-
-func onIRCWhois(srv *Server, ev *IRCEvent) {
-	nick := ev.Args[1]
-	data := ev.Args[2:]
-	win := srv.windows.NamedOrActive(nick)
-	WriteWhois(win, nick, data)
-}
-
-func onIRCMessage(srv *Server, ev *IRCEvent) {
-	win := srv.windows.Index(0)
-	WriteMessage(win, strings.Join(ev.Args[1:], " "))
+	srv.windows.events.Emit("ui.DIRTY", nil)
 }
 
 func onIRCNames(srv *Server, ev *IRCEvent) {
 	if ev.Code == "PART" || ev.Code == "KICK" {
-		myNick := srv.CurrentNick()
-		if ev.Nick == myNick {
+		if srv.isMe(ev.Nick) {
 			// dont bother trying to get names when we are the one leaving
 			return
 		}
 	}
 	target := ev.Target
-	if strings.HasPrefix(target, "#") {
+	if srv.isChannel(target) {
 		srv.IRCDoAsync(func(conn *irc.Connection) error {
 			conn.SendRawf("NAMES :%s", target)
 			return nil
@@ -387,15 +521,27 @@ func onIRCNames(srv *Server, ev *IRCEvent) {
 
 func onIRCJoin(srv *Server, ev *IRCEvent) {
 	target := ev.Target
+	srv.users.Seen(ev.Nick, ev.User, ev.Host)
+	if len(ev.Args) > 2 {
+		// extended-join includes the account and real name
+		account := ev.Args[1]
+		if account == "*" {
+			account = ""
+		}
+		srv.users.Update(ev.Nick, func(u *UserInfo) {
+			u.Account = account
+			u.RealName = ev.Args[2]
+		})
+	}
 	win := srv.windows.Named(target)
 	nick := SomeNick(ev.Nick)
-	if ev.Nick == srv.CurrentNick() {
+	if srv.isMe(ev.Nick) {
 		nick.me = true
 	}
 	if win == nil {
 		ch := &Channel{
 			bufferedWindow: newBufferedWindow(target, srv.events),
-			users:          []User{},
+			users:          NewChannelUsers(srv.casemap.Fold),
 		}
 		srv.windows.Append(ch)
 		win = ch
@@ -405,11 +551,23 @@ func onIRCJoin(srv *Server, ev *IRCEvent) {
 				conn.Mode(target)
 				return nil
 			})
+			// populate the user registry with everyone in the channel
+			srv.who(target, nil)
 		}
+		srv.applyChannelLogging(ch)
+	}
+	if nick.me {
+		srv.fetchHistory(win, false)
 	}
 	if ch, ok := win.(*Channel); ok {
 		ch.AddUser(SomeUser(nick.string))
 	}
+	if isIgnored(srv, ev, target, IgnoreJoins) {
+		return
+	}
+	if !nick.me && srv.channelConfig(target).HideJoins {
+		return
+	}
 	WriteJoin(win, nick)
 }
 
@@ -417,7 +575,7 @@ func onIRCPart(srv *Server, ev *IRCEvent) {
 	target := ev.Target
 	nick := SomeNick(ev.Nick)
 	win := srv.windows.Named(target)
-	if ev.Nick == srv.CurrentNick() {
+	if srv.isMe(ev.Nick) {
 		nick.me = true
 	}
 	if win == nil {
@@ -430,19 +588,41 @@ func onIRCPart(srv *Server, ev *IRCEvent) {
 	if ch, ok := win.(*Channel); ok {
 		ch.DeleteUser(nick.string)
 	}
+	srv.forgetUnlessShared(nick.string)
+	if isIgnored(srv, ev, target, IgnoreParts) {
+		return
+	}
+	if !nick.me && srv.channelConfig(target).HideParts {
+		return
+	}
 	WritePart(win, nick, ev.Message)
 }
 
 func onIRCAction(srv *Server, ev *IRCEvent) {
+	if srv.playback(ev) {
+		return
+	}
+	if srv.isMe(ev.Nick) && srv.caps.Enabled("echo-message") {
+		onIRCEcho(srv, ev, true)
+		return
+	}
 	direct := false
 	target := ev.Target
 	nick := ev.Nick
 	myNick := MyNick(srv.CurrentNick())
-	if target == myNick.string {
+	if srv.isMe(target) {
 		// its a direct message!
 		direct = true
 		target = nick
 	}
+	srv.stopTyping(target, nick)
+	channel := target
+	if direct {
+		channel = ""
+	}
+	if isIgnored(srv, ev, channel, IgnoreMsgs) {
+		return
+	}
 	win := srv.windows.Named(target)
 	if win == nil {
 		if !direct {
@@ -455,19 +635,38 @@ func onIRCAction(srv *Server, ev *IRCEvent) {
 		}
 	}
 	msg := SomeMessage(ev.Message, myNick)
-	WriteAction(win, SomeNick(nick), msg)
+	msg.refsMe = srv.mentionsMe(ev.Message) || !direct && srv.channelConfig(target).Highlighted(ev.Message)
+	WriteAction(srv.messageWindow(win, ev, false), SomeNick(nick), msg)
+	if direct || msg.refsMe {
+		srv.logAway(target, SomeNick(nick), msg)
+	}
 }
 
 func onIRCPrivmsg(srv *Server, ev *IRCEvent) {
+	if srv.playback(ev) {
+		return
+	}
+	if srv.isMe(ev.Nick) && srv.caps.Enabled("echo-message") {
+		onIRCEcho(srv, ev, false)
+		return
+	}
 	direct := false
 	target := ev.Target
 	nick := ev.Nick
 	myNick := MyNick(srv.CurrentNick())
-	if target == myNick.string {
+	if srv.isMe(target) {
 		// its a direct message!
 		direct = true
 		target = nick
 	}
+	srv.stopTyping(target, nick)
+	channel := target
+	if direct {
+		channel = ""
+	}
+	if isIgnored(srv, ev, channel, IgnoreMsgs) {
+		return
+	}
 	win := srv.windows.Named(target)
 	if win == nil {
 		if !direct {
@@ -480,19 +679,41 @@ func onIRCPrivmsg(srv *Server, ev *IRCEvent) {
 		}
 	}
 	msg := SomeMessage(ev.Message, myNick)
-	WritePrivmsg(win, SomeNick(nick), msg)
+	msg.refsMe = srv.mentionsMe(ev.Message) || !direct && srv.channelConfig(target).Highlighted(ev.Message)
+	WritePrivmsg(srv.messageWindow(win, ev, false), SomeNick(nick), msg)
+	if direct || msg.refsMe {
+		srv.logAway(target, SomeNick(nick), msg)
+	}
 }
 
 func onIRCNotice(srv *Server, ev *IRCEvent) {
+	if srv.playback(ev) {
+		return
+	}
+	if srv.isMe(ev.Nick) && srv.caps.Enabled("echo-message") {
+		onIRCEchoNotice(srv, ev)
+		return
+	}
 	me := srv.CurrentNick()
 	target := SomeTarget(ev.Target, me)
 	// "*" is used by at least Freenode when you don't yet have a nick.
-	if target.string == "*" {
+	if target.string == "*" || srv.isMe(target.string) {
 		target.me = true
 	}
 	if target.me {
 		target = SomeTarget(ev.Nick, me)
 	}
+	channel := ""
+	if srv.isChannel(target.string) {
+		channel = target.string
+	}
+	level := IgnoreNotices
+	if strings.Contains(ev.Message, "\x01") {
+		level = IgnoreCTCPs
+	}
+	if isIgnored(srv, ev, channel, level) {
+		return
+	}
 	win := srv.windows.Named(target.string)
 	if win == nil {
 		win = srv.windows.Index(0)
@@ -508,8 +729,252 @@ func onIRCNotice(srv *Server, ev *IRCEvent) {
 func onIRCQuit(srv *Server, ev *IRCEvent) {
 	nick := SomeNick(ev.Nick)
 	message := ev.Message
-	if ev.Nick == srv.CurrentNick() {
+	srv.users.Remove(nick.string)
+	if srv.isMe(ev.Nick) {
 		nick.me = true
+	} else {
+		// silently remove the user from channels where their quits are ignored
+		for _, win := range srv.windows.Windows() {
+			if ch, ok := win.(*Channel); ok && isIgnored(srv, ev, ch.Title(), IgnoreQuits) {
+				ch.DeleteUser(nick.string)
+			}
+		}
+		if isIgnored(srv, ev, "", IgnoreQuits) {
+			return
+		}
 	}
 	WriteQuit(srv.windows, nick, message)
 }
+
+func onIRC301(srv *Server, ev *IRCEvent) {
+	// RPL_AWAY
+	if len(ev.Args) < 3 {
+		return
+	}
+	nick := ev.Args[1]
+	message := ev.Args[2]
+	if srv.whoises.Pending(nick) {
+		onIRCWhois(srv, ev)
+		return
+	}
+	if srv.away.SeenAway(nick, message) {
+		return
+	}
+	win := srv.windows.NamedOrActive(nick)
+	WriteAway(win, SomeNick(nick), message)
+}
+
+func onIRC305(srv *Server, _ *IRCEvent) {
+	// RPL_UNAWAY
+	d, logged := srv.away.setBack()
+	WriteBack(srv.windows.Index(0), d, logged)
+	if win := srv.windows.Named("awaylog"); win != nil {
+		WriteBack(win, d, logged)
+	}
+}
+
+func onIRC306(srv *Server, _ *IRCEvent) {
+	// RPL_NOWAWAY
+	srv.away.setAway()
+	_, message := srv.away.Away()
+	WriteMarkedAway(srv.windows.Index(0), message)
+	WriteMarkedAway(srv.awayLog(), message)
+}
+
+func onIRC322(srv *Server, ev *IRCEvent) {
+	// RPL_LIST
+	if len(ev.Args) < 3 {
+		return
+	}
+	cl, ok := srv.windows.Named("list").(*ChannelList)
+	if !ok {
+		return
+	}
+	users, _ := strconv.Atoi(ev.Args[2])
+	topic := ""
+	if len(ev.Args) > 3 {
+		topic = ev.Args[3]
+	}
+	cl.Add(ChannelListEntry{Name: ev.Args[1], Users: users, Topic: topic})
+	if cl.Len()%100 == 0 {
+		srv.events.Emit("ui.DIRTY", nil)
+	}
+}
+
+func onIRC323(srv *Server, _ *IRCEvent) {
+	// RPL_LISTEND
+	if cl, ok := srv.windows.Named("list").(*ChannelList); ok {
+		cl.Complete()
+	}
+}
+
+func onIRC005(srv *Server, ev *IRCEvent) {
+	// RPL_ISUPPORT
+	if len(ev.Args) < 3 {
+		return
+	}
+	// the first argument is our nick and the last is a human readable message
+	srv.isupport.Parse(ev.Args[1 : len(ev.Args)-1])
+	if v, ok := srv.isupport.Value("NETWORK"); ok && v != "" {
+		srv.setNetwork(v)
+	}
+	cm := CaseMappingRFC1459
+	if v, ok := srv.isupport.Value("CASEMAPPING"); ok && v != "" {
+		cm = CaseMapping(v)
+	}
+	if srv.casemap.Set(cm) {
+		// users are indexed by their folded nick
+		for _, win := range srv.windows.Windows() {
+			if ch, ok := win.(*Channel); ok {
+				ch.mu.Lock()
+				ch.users.Reindex()
+				ch.mu.Unlock()
+			}
+		}
+	}
+}
+
+func onIRC352(srv *Server, ev *IRCEvent) {
+	// RPL_WHOREPLY
+	if len(ev.Args) < 8 {
+		return
+	}
+	realname := ev.Args[7]
+	// the last argument is "<hopcount> <realname>"
+	if p := strings.SplitN(realname, " ", 2); len(p) == 2 {
+		realname = p[1]
+	}
+	srv.whoReply("", WhoResult{
+		UserInfo: UserInfo{
+			Nick:     ev.Args[5],
+			User:     ev.Args[2],
+			Host:     ev.Args[3],
+			RealName: realname,
+		},
+		Channel: ev.Args[1],
+		Flags:   ev.Args[6],
+	})
+}
+
+func onIRC354(srv *Server, ev *IRCEvent) {
+	// RPL_WHOSPCRPL, in the order requested by Server.who
+	if len(ev.Args) < 9 || !srv.whos.Expecting(ev.Args[1]) {
+		// not a reply to one of our queries, the fields may differ
+		return
+	}
+	account := ev.Args[7]
+	if account == "0" {
+		account = ""
+	}
+	srv.whoReply(ev.Args[1], WhoResult{
+		UserInfo: UserInfo{
+			Nick:     ev.Args[5],
+			User:     ev.Args[3],
+			Host:     ev.Args[4],
+			RealName: ev.Args[8],
+			Account:  account,
+		},
+		Channel: ev.Args[2],
+		Flags:   ev.Args[6],
+	})
+}
+
+func onIRC315(srv *Server, ev *IRCEvent) {
+	// RPL_ENDOFWHO
+	if len(ev.Args) < 2 {
+		return
+	}
+	q := srv.whos.Done(ev.Args[1])
+	if q == nil {
+		return
+	}
+	srv.markAway(q.away)
+	if q.Window == nil {
+		return
+	}
+	WriteWho(q.Window, q.Mask, q.Results)
+}
+
+func onIRC302(srv *Server, ev *IRCEvent) {
+	// RPL_USERHOST
+	q, ok := srv.userhosts.pop()
+	if !ok {
+		return
+	}
+	var found UserInfo
+	ok = false
+	for _, r := range strings.Fields(ev.Message) {
+		u, valid := parseUserhost(r)
+		if !valid {
+			continue
+		}
+		srv.users.Seen(u.Nick, u.User, u.Host)
+		if srv.casemap.Equal(u.Nick, q.nick) {
+			found = u
+			ok = true
+		}
+	}
+	q.fn(found, ok)
+}
+
+// modeListNumerics maps list mode replies to the mode they list.
+var modeListNumerics = map[string]string{
+	"367": "b", // RPL_BANLIST
+	"348": "e", // RPL_EXCEPTLIST
+	"346": "I", // RPL_INVITELIST
+}
+
+// modeListEndNumerics maps end of list mode replies to the mode they list.
+var modeListEndNumerics = map[string]string{
+	"368": "b", // RPL_ENDOFBANLIST
+	"349": "e", // RPL_ENDOFEXCEPTLIST
+	"347": "I", // RPL_ENDOFINVITELIST
+}
+
+// modeListArgs returns the mode and the arguments of a list mode reply
+// in the form <me> <channel> <mask> [<setter> <timestamp>].
+func modeListArgs(ev *IRCEvent) (string, []string) {
+	if ev.Code == "728" || ev.Code == "729" {
+		// RPL_QUIETLIST and RPL_ENDOFQUIETLIST include the mode character
+		// after the channel.
+		if len(ev.Args) < 3 {
+			return "", nil
+		}
+		return ev.Args[2], append(ev.Args[:2:2], ev.Args[3:]...)
+	}
+	if m, ok := modeListNumerics[ev.Code]; ok {
+		return m, ev.Args
+	}
+	return modeListEndNumerics[ev.Code], ev.Args
+}
+
+func onIRCModeListEntry(srv *Server, ev *IRCEvent) {
+	mode, args := modeListArgs(ev)
+	if mode == "" || len(args) < 3 {
+		return
+	}
+	e := ModeListEntry{Mask: args[2]}
+	if len(args) > 4 {
+		e.SetBy = args[3]
+		if ts, err := strconv.ParseInt(args[4], 10, 64); err == nil {
+			e.SetAt = time.Unix(ts, 0)
+		}
+	}
+	srv.modeLists.Add(args[1], mode, e)
+}
+
+func onIRCModeListEnd(srv *Server, ev *IRCEvent) {
+	mode, args := modeListArgs(ev)
+	if mode == "" || len(args) < 2 {
+		return
+	}
+	chanName := args[1]
+	entries := srv.modeLists.Take(chanName, mode)
+	win := srv.windows.Named(chanName)
+	ch, ok := win.(*Channel)
+	if !ok {
+		return
+	}
+	ch.SetModeList(mode, entries)
+	WriteModeList(win, mode, entries)
+}
@@ -0,0 +1,280 @@
+package squirssi
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// IgnoreLevel is a set of message kinds that an Ignore applies to.
+type IgnoreLevel int
+
+const (
+	IgnoreMsgs IgnoreLevel = 1 << iota
+	IgnoreNotices
+	IgnoreCTCPs
+	IgnoreJoins
+	IgnoreParts
+	IgnoreQuits
+	IgnoreNicks
+
+	IgnoreAll = IgnoreMsgs | IgnoreNotices | IgnoreCTCPs | IgnoreJoins | IgnoreParts | IgnoreQuits | IgnoreNicks
+)
+
+var ignoreLevelNames = []struct {
+	name  string
+	level IgnoreLevel
+}{
+	{"MSGS", IgnoreMsgs},
+	{"NOTICES", IgnoreNotices},
+	{"CTCPS", IgnoreCTCPs},
+	{"JOINS", IgnoreJoins},
+	{"PARTS", IgnoreParts},
+	{"QUITS", IgnoreQuits},
+	{"NICKS", IgnoreNicks},
+}
+
+// ParseIgnoreLevels returns the IgnoreLevel described by the given level names.
+func ParseIgnoreLevels(names []string) (IgnoreLevel, error) {
+	var l IgnoreLevel
+	for _, n := range names {
+		n = strings.ToUpper(n)
+		if n == "ALL" {
+			l |= IgnoreAll
+			continue
+		}
+		found := false
+		for _, v := range ignoreLevelNames {
+			if v.name == n {
+				l |= v.level
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, errors.Errorf("unknown ignore level: %s", n)
+		}
+	}
+	return l, nil
+}
+
+func (l IgnoreLevel) String() string {
+	if l&IgnoreAll == IgnoreAll {
+		return "ALL"
+	}
+	var names []string
+	for _, v := range ignoreLevelNames {
+		if l&v.level != 0 {
+			names = append(names, v.name)
+		}
+	}
+	return strings.Join(names, " ")
+}
+
+func (l IgnoreLevel) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+func (l *IgnoreLevel) UnmarshalText(text []byte) error {
+	v, err := ParseIgnoreLevels(strings.Fields(string(text)))
+	if err != nil {
+		return err
+	}
+	*l = v
+	return nil
+}
+
+// An Ignore silences messages from users matching a hostmask.
+type Ignore struct {
+	// Mask is a nick!user@host glob, or a regular expression if Regexp is set.
+	Mask   string `json:"mask"`
+	Regexp bool   `json:"regexp,omitempty"`
+	// Channels limits the Ignore to the given channels. Empty means everywhere.
+	Channels []string    `json:"channels,omitempty"`
+	Levels   IgnoreLevel `json:"levels"`
+	// Expires is when the Ignore is automatically removed. Zero means never.
+	Expires time.Time `json:"expires"`
+
+	re *regexp.Regexp
+}
+
+// globToRegexp converts a nick!user@host glob into an equivalent regular expression.
+func globToRegexp(glob string) string {
+	r := regexp.QuoteMeta(glob)
+	r = strings.ReplaceAll(r, `\*`, `.*`)
+	r = strings.ReplaceAll(r, `\?`, `.`)
+	return "^" + r + "$"
+}
+
+func (ig *Ignore) compile() error {
+	var expr string
+	if ig.Regexp {
+		expr = ig.Mask
+	} else {
+		mask := ig.Mask
+		if !strings.ContainsAny(mask, "!@") {
+			// a bare nickname
+			mask = mask + "!*@*"
+		}
+		expr = globToRegexp(mask)
+	}
+	re, err := regexp.Compile("(?i)" + expr)
+	if err != nil {
+		return errors.Wrapf(err, "invalid ignore mask %s", ig.Mask)
+	}
+	ig.re = re
+	return nil
+}
+
+// Expired returns true if the Ignore has expired as of now.
+func (ig *Ignore) Expired(now time.Time) bool {
+	return !ig.Expires.IsZero() && now.After(ig.Expires)
+}
+
+// Matches returns true if the Ignore applies to the given hostmask in
+// channel for the given level. channel may be empty for messages that aren't
+// sent to a channel. Channel names are compared using casemap.
+func (ig *Ignore) Matches(hostmask, channel string, level IgnoreLevel, casemap *CaseMapper) bool {
+	if ig.Levels&level == 0 {
+		return false
+	}
+	if len(ig.Channels) > 0 {
+		found := false
+		for _, c := range ig.Channels {
+			if casemap.Equal(c, channel) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return ig.re != nil && ig.re.MatchString(hostmask)
+}
+
+const ignoreStoreSection = "ignores"
+
+// An IgnoreList contains all active Ignores.
+type IgnoreList struct {
+	entries []*Ignore
+	store   *Store
+	casemap *CaseMapper
+
+	mu sync.RWMutex
+}
+
+func NewIgnoreList(store *Store, casemap *CaseMapper) *IgnoreList {
+	return &IgnoreList{store: store, casemap: casemap}
+}
+
+// Load restores the IgnoreList from the Store.
+func (il *IgnoreList) Load() error {
+	var entries []*Ignore
+	if err := il.store.Load(ignoreStoreSection, &entries); err != nil {
+		return err
+	}
+	var res []*Ignore
+	for _, ig := range entries {
+		if err := ig.compile(); err != nil {
+			return err
+		}
+		res = append(res, ig)
+	}
+	il.mu.Lock()
+	defer il.mu.Unlock()
+	il.entries = res
+	return nil
+}
+
+func (il *IgnoreList) save() error {
+	return il.store.Save(ignoreStoreSection, il.entries)
+}
+
+// Add adds a new Ignore to the list.
+func (il *IgnoreList) Add(ig Ignore) error {
+	if err := ig.compile(); err != nil {
+		return err
+	}
+	il.mu.Lock()
+	defer il.mu.Unlock()
+	il.entries = append(il.entries, &ig)
+	return il.save()
+}
+
+// Remove removes the Ignore at the given index.
+func (il *IgnoreList) Remove(idx int) (Ignore, error) {
+	il.mu.Lock()
+	defer il.mu.Unlock()
+	return il.remove(idx)
+}
+
+// remove removes the Ignore at the given index.
+// il.mu must be held.
+func (il *IgnoreList) remove(idx int) (Ignore, error) {
+	if idx < 0 || idx >= len(il.entries) {
+		return Ignore{}, errors.Errorf("no ignore #%d", idx)
+	}
+	ig := *il.entries[idx]
+	il.entries = append(il.entries[:idx], il.entries[idx+1:]...)
+	return ig, il.save()
+}
+
+// RemoveMask removes the Ignore with the given mask.
+func (il *IgnoreList) RemoveMask(mask string) (Ignore, error) {
+	il.mu.Lock()
+	defer il.mu.Unlock()
+	for i, ig := range il.entries {
+		if strings.EqualFold(ig.Mask, mask) {
+			return il.remove(i)
+		}
+	}
+	return Ignore{}, errors.Errorf("no ignore with mask %s", mask)
+}
+
+// Entries returns a copy of the Ignores in the list.
+func (il *IgnoreList) Entries() []Ignore {
+	il.mu.RLock()
+	defer il.mu.RUnlock()
+	res := make([]Ignore, len(il.entries))
+	for i, ig := range il.entries {
+		res[i] = *ig
+	}
+	return res
+}
+
+// Ignored returns true if messages of the given level from hostmask in
+// channel should be ignored. Expired entries are removed along the way.
+func (il *IgnoreList) Ignored(hostmask, channel string, level IgnoreLevel) bool {
+	il.mu.Lock()
+	defer il.mu.Unlock()
+	now := time.Now()
+	ignored := false
+	expired := false
+	res := il.entries[:0]
+	for _, ig := range il.entries {
+		if ig.Expired(now) {
+			expired = true
+			continue
+		}
+		res = append(res, ig)
+		if ig.Matches(hostmask, channel, level, il.casemap) {
+			ignored = true
+		}
+	}
+	il.entries = res
+	if expired {
+		go func() {
+			il.mu.RLock()
+			defer il.mu.RUnlock()
+			if err := il.save(); err != nil {
+				logrus.Warnln("ignore: failed to save expired ignores:", err)
+			}
+		}()
+	}
+	return ignored
+}
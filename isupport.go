@@ -0,0 +1,74 @@
+package squirssi
+
+import (
+	"strings"
+	"sync"
+)
+
+// ISupport contains the features advertised by the server with RPL_ISUPPORT (005).
+type ISupport struct {
+	values map[string]string
+
+	mu sync.RWMutex
+}
+
+func NewISupport() *ISupport {
+	return &ISupport{values: make(map[string]string)}
+}
+
+// Reset forgets all advertised features.
+func (is *ISupport) Reset() {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+	is.values = make(map[string]string)
+}
+
+// Parse adds the given tokens to the known features.
+// Tokens are in the form KEY, KEY=VALUE, or -KEY to remove a feature.
+func (is *ISupport) Parse(tokens []string) {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+	for _, tok := range tokens {
+		if tok == "" {
+			continue
+		}
+		if tok[0] == '-' {
+			delete(is.values, strings.ToUpper(tok[1:]))
+			continue
+		}
+		kv := strings.SplitN(tok, "=", 2)
+		v := ""
+		if len(kv) > 1 {
+			v = kv[1]
+		}
+		is.values[strings.ToUpper(kv[0])] = v
+	}
+}
+
+// Value returns the value of the given feature, and whether it is supported.
+func (is *ISupport) Value(key string) (string, bool) {
+	is.mu.RLock()
+	defer is.mu.RUnlock()
+	v, ok := is.values[key]
+	return v, ok
+}
+
+// Has returns true if the server advertised the given feature.
+func (is *ISupport) Has(key string) bool {
+	_, ok := is.Value(key)
+	return ok
+}
+
+// defaultChanTypes are the channel prefixes used when the server does not
+// advertise CHANTYPES.
+const defaultChanTypes = "#&"
+
+// isChannel returns true if name starts with one of the server's channel
+// prefixes.
+func (srv *Server) isChannel(name string) bool {
+	types, ok := srv.isupport.Value("CHANTYPES")
+	if !ok {
+		types = defaultChanTypes
+	}
+	return name != "" && strings.IndexByte(types, name[0]) >= 0
+}
@@ -0,0 +1,158 @@
+package squirssi
+
+import (
+	"strings"
+	"sync"
+
+	"code.dopame.me/veonik/squircy3/irc"
+)
+
+// ChannelKeys remembers the keys used to join channels during the session
+// so that rejoining works without typing the key again.
+type ChannelKeys struct {
+	keys    map[string]string
+	casemap *CaseMapper
+
+	mu sync.Mutex
+}
+
+func NewChannelKeys(casemap *CaseMapper) *ChannelKeys {
+	return &ChannelKeys{keys: make(map[string]string), casemap: casemap}
+}
+
+// Get returns the remembered key for channel.
+func (ck *ChannelKeys) Get(channel string) (string, bool) {
+	ck.mu.Lock()
+	defer ck.mu.Unlock()
+	k, ok := ck.keys[ck.casemap.Fold(channel)]
+	return k, ok
+}
+
+// Set remembers the key for channel.
+func (ck *ChannelKeys) Set(channel, key string) {
+	ck.mu.Lock()
+	defer ck.mu.Unlock()
+	ck.keys[ck.casemap.Fold(channel)] = key
+}
+
+// Forget removes the remembered key for channel.
+func (ck *ChannelKeys) Forget(channel string) {
+	ck.mu.Lock()
+	defer ck.mu.Unlock()
+	delete(ck.keys, ck.casemap.Fold(channel))
+}
+
+// channelKey returns the key to use when joining channel, if one is known.
+func (srv *Server) channelKey(channel string) string {
+	if k, ok := srv.keys.Get(channel); ok {
+		return k
+	}
+	if c, ok := srv.channels.Get(srv.Network(), channel); ok && c.Key != "" {
+		return c.Key
+	}
+	if ch, ok := srv.windows.Named(channel).(*Channel); ok {
+		ch.mu.RLock()
+		defer ch.mu.RUnlock()
+		return ch.modes['k']
+	}
+	return ""
+}
+
+// maxJoinLineLength keeps JOIN commands well within the 512 byte limit.
+const maxJoinLineLength = 400
+
+// joinLines builds the JOIN commands for the given channels and keys.
+// Channels with keys must come before channels without in a single JOIN.
+func joinLines(channels, keys []string) []string {
+	var keyed, keyedKeys, unkeyed []string
+	for i, c := range channels {
+		if i < len(keys) && keys[i] != "" {
+			keyed = append(keyed, c)
+			keyedKeys = append(keyedKeys, keys[i])
+		} else {
+			unkeyed = append(unkeyed, c)
+		}
+	}
+	all := append(keyed, unkeyed...)
+	var res []string
+	for len(all) > 0 {
+		n, size := 0, len("JOIN ")
+		for n < len(all) {
+			size += len(all[n]) + 1
+			if n < len(keyedKeys) {
+				size += len(keyedKeys[n]) + 1
+			}
+			if n > 0 && size > maxJoinLineLength {
+				break
+			}
+			n++
+		}
+		line := "JOIN " + strings.Join(all[:n], ",")
+		if k := keyedKeys[:minInt(n, len(keyedKeys))]; len(k) > 0 {
+			line += " " + strings.Join(k, ",")
+		}
+		res = append(res, line)
+		all = all[n:]
+		keyedKeys = keyedKeys[minInt(n, len(keyedKeys)):]
+	}
+	return res
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// join joins the given channels, using any remembered keys where no key is given.
+func (srv *Server) join(channels, keys []string) {
+	keys = append([]string{}, keys...)
+	for i, c := range channels {
+		if i >= len(keys) {
+			keys = append(keys, "")
+		}
+		if keys[i] != "" {
+			srv.keys.Set(c, keys[i])
+		} else {
+			keys[i] = srv.channelKey(c)
+		}
+	}
+	lines := joinLines(channels, keys)
+	srv.IRCDoAsync(func(conn *irc.Connection) error {
+		for _, l := range lines {
+			conn.SendRaw(l)
+		}
+		return nil
+	})
+}
+
+// joinErrorHints are suggestions shown when joining a channel fails.
+var joinErrorHints = map[string]string{
+	"471": "The channel is full, try again later.",
+	"473": "The channel is invite only, ask an operator for an invite.",
+	"474": "You are banned from the channel.",
+	"475": "The channel requires a key, use /join %s <key>.",
+	"477": "You need to identify with services to join the channel.",
+}
+
+func onIRCJoinError(srv *Server, ev *IRCEvent) {
+	if len(ev.Args) < 2 {
+		return
+	}
+	channel := ev.Args[1]
+	hint := joinErrorHints[ev.Code]
+	switch ev.Code {
+	case "473":
+		if srv.isupport.Has("KNOCK") {
+			hint = "The channel is invite only, use /knock %s to request an invite."
+		}
+	case "475":
+		// the remembered key was wrong
+		srv.keys.Forget(channel)
+	}
+	if strings.Contains(hint, "%s") {
+		hint = strings.ReplaceAll(hint, "%s", channel)
+	}
+	WriteJoinError(srv.windows.Index(0), channel, ev.Message, hint)
+}
@@ -0,0 +1,172 @@
+package squirssi
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"code.dopame.me/veonik/squircy3/irc"
+)
+
+// labelTimeout is how long a labeled request is remembered without a reply.
+const labelTimeout = 2 * time.Minute
+
+// A labeledRequest is a command sent with a label, waiting for its replies.
+type labeledRequest struct {
+	window Window
+	sent   time.Time
+}
+
+// A LabelManager correlates replies with the commands sent using the
+// IRCv3 labeled-response capability.
+type LabelManager struct {
+	next     int
+	requests map[string]labeledRequest
+	// batches maps the reference of open batches to their label, if any.
+	batches map[string]string
+
+	mu sync.Mutex
+}
+
+func NewLabelManager() *LabelManager {
+	return &LabelManager{requests: make(map[string]labeledRequest), batches: make(map[string]string)}
+}
+
+// Reset forgets all pending requests and open batches.
+func (lm *LabelManager) Reset() {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	lm.requests = make(map[string]labeledRequest)
+	lm.batches = make(map[string]string)
+}
+
+// New returns a label for a command sent from win.
+func (lm *LabelManager) New(win Window) string {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	now := time.Now()
+	for l, r := range lm.requests {
+		if now.Sub(r.sent) > labelTimeout {
+			delete(lm.requests, l)
+		}
+	}
+	lm.next++
+	l := "sq" + strconv.Itoa(lm.next)
+	lm.requests[l] = labeledRequest{window: win, sent: now}
+	return l
+}
+
+// Window returns the window the labeled command was sent from.
+func (lm *LabelManager) Window(label string) (Window, bool) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	r, ok := lm.requests[label]
+	return r.window, ok
+}
+
+// Done forgets the labeled command.
+func (lm *LabelManager) Done(label string) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	delete(lm.requests, label)
+}
+
+// StartBatch records an open batch, and the label it replies to, if any.
+func (lm *LabelManager) StartBatch(ref, label string) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	lm.batches[ref] = label
+}
+
+// EndBatch forgets an open batch and returns its label, if any.
+func (lm *LabelManager) EndBatch(ref string) string {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	l := lm.batches[ref]
+	delete(lm.batches, ref)
+	return l
+}
+
+// Label returns the label of the command an event replies to, either
+// directly or through the batch it is part of.
+func (lm *LabelManager) Label(ev *IRCEvent) string {
+	if l, ok := ev.Tags["label"]; ok {
+		return l
+	}
+	ref, ok := ev.Tags["batch"]
+	if !ok {
+		return ""
+	}
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	return lm.batches[ref]
+}
+
+// newLabel returns a label for a command sent from win, or an empty string
+// if the server does not support labeled-response.
+func (srv *Server) newLabel(win Window) string {
+	if win == nil || !srv.caps.Enabled("labeled-response") {
+		return ""
+	}
+	return srv.labels.New(win)
+}
+
+// sendLabeled sends a raw line from win, labeling it if the server
+// supports labeled-response. The label is returned, or an empty string.
+func (srv *Server) sendLabeled(win Window, line string) string {
+	label := srv.newLabel(win)
+	if label != "" {
+		line = formatTags(map[string]string{"label": label}) + line
+	}
+	srv.IRCDoAsync(func(conn *irc.Connection) error {
+		conn.SendRaw(line)
+		return nil
+	})
+	return label
+}
+
+// labeledWindow returns the window that sent the command ev replies to,
+// if it is still open.
+func (srv *Server) labeledWindow(ev *IRCEvent) (Window, bool) {
+	l := srv.labels.Label(ev)
+	if l == "" {
+		return nil, false
+	}
+	win, ok := srv.labels.Window(l)
+	if !ok || srv.windows.Named(win.Title()) != win {
+		return nil, false
+	}
+	return win, true
+}
+
+// labelReplied forgets the labeled command ev replies to if it is the only
+// reply. Replies made of several messages are sent in a batch.
+func (srv *Server) labelReplied(ev *IRCEvent) {
+	if l, ok := ev.Tags["label"]; ok && ev.Code != "BATCH" {
+		srv.labels.Done(l)
+	}
+}
+
+func onIRCBatch(srv *Server, ev *IRCEvent) {
+	if len(ev.Args) == 0 || len(ev.Args[0]) < 2 {
+		return
+	}
+	ref := ev.Args[0][1:]
+	switch ev.Args[0][0] {
+	case '+':
+		srv.labels.StartBatch(ref, ev.Tags["label"])
+		if len(ev.Args) > 2 && (ev.Args[1] == "chathistory" || ev.Args[1] == "draft/chathistory") {
+			srv.chathistory.StartBatch(ref, ev.Args[2])
+		}
+	case '-':
+		if l := srv.labels.EndBatch(ref); l != "" {
+			srv.labels.Done(l)
+		}
+		srv.chathistory.EndBatch(ref)
+	}
+}
+
+func onIRCAck(srv *Server, ev *IRCEvent) {
+	// the labeled command was accepted without any other reply
+	srv.labelReplied(ev)
+}
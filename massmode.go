@@ -0,0 +1,146 @@
+package squirssi
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// modeParamPrefixes are the channel membership modes that can be batched
+// when applied to several nicks at once.
+var modeParamPrefixes = map[byte]struct{}{'q': {}, 'a': {}, 'o': {}, 'h': {}, 'v': {}}
+
+// defaultMaxModes is the number of parameterized modes allowed in a single
+// MODE command when the server does not advertise MODES.
+const defaultMaxModes = 3
+
+// maxModeLineLength keeps generated MODE commands well within the 512 byte
+// limit of an IRC message, including the prefix added by the server.
+const maxModeLineLength = 400
+
+// maxModes returns the number of parameterized modes the server accepts in
+// a single MODE command.
+func (srv *Server) maxModes() int {
+	v, ok := srv.isupport.Value("MODES")
+	if !ok {
+		return defaultMaxModes
+	}
+	if v == "" {
+		// no limit, but keep lines reasonably sized
+		return 100
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		return defaultMaxModes
+	}
+	return n
+}
+
+// expandModeTargets resolves the given nicks and wildcard patterns against
+// the users in ch. Patterns that match nobody are dropped, nicks and
+// hostmasks are kept as-is.
+func expandModeTargets(ch *Channel, targets []string, fold func(string) string) []string {
+	var res []string
+	seen := make(map[string]struct{})
+	add := func(nick string) {
+		k := fold(nick)
+		if _, ok := seen[k]; ok {
+			return
+		}
+		seen[k] = struct{}{}
+		res = append(res, nick)
+	}
+	var users []string
+	for _, t := range targets {
+		if !strings.ContainsAny(t, "*?") || strings.ContainsAny(t, "!@") {
+			// a nick, or a hostmask for modes like +q
+			add(t)
+			continue
+		}
+		if ch == nil {
+			continue
+		}
+		re, err := regexp.Compile("(?i)" + globToRegexp(t))
+		if err != nil {
+			continue
+		}
+		if users == nil {
+			users = ch.Users()
+		}
+		for _, u := range users {
+			if re.MatchString(u) {
+				add(u)
+			}
+		}
+	}
+	return res
+}
+
+// repeatedMode returns the mode being applied if args is a single mode
+// change repeated for each parameter, such as "+ooo a b c".
+func repeatedMode(args []string) (string, bool) {
+	if len(args) < 2 || len(args[0]) < 2 || (args[0][0] != '+' && args[0][0] != '-') {
+		return "", false
+	}
+	m := args[0][1]
+	if _, ok := modeParamPrefixes[m]; !ok {
+		return "", false
+	}
+	for i := 2; i < len(args[0]); i++ {
+		if args[0][i] != m {
+			return "", false
+		}
+	}
+	if len(args[0])-1 != 1 && len(args[0])-1 != len(args)-1 {
+		return "", false
+	}
+	return args[0][:2], true
+}
+
+// batchModes builds MODE commands applying mode to each of the given nicks,
+// using as few commands as the server allows.
+func batchModes(target, mode string, nicks []string, max int) []string {
+	if len(mode) != 2 || len(nicks) == 0 {
+		return nil
+	}
+	var res []string
+	for len(nicks) > 0 {
+		n := 0
+		size := len("MODE ") + len(target) + 2
+		for n < len(nicks) && n < max {
+			size += len(nicks[n]) + 2
+			if n > 0 && size > maxModeLineLength {
+				break
+			}
+			n++
+		}
+		res = append(res, "MODE "+target+" "+mode[:1]+strings.Repeat(mode[1:], n)+" "+strings.Join(nicks[:n], " "))
+		nicks = nicks[n:]
+	}
+	return res
+}
+
+// massMode applies mode to the given nicks and patterns in channel, skipping
+// users who already have (or lack) the mode.
+func (srv *Server) massMode(channel, mode string, targets []string) []string {
+	var ch *Channel
+	if win, ok := srv.windows.Named(channel).(*Channel); ok {
+		ch = win
+	}
+	nicks := expandModeTargets(ch, targets, srv.casemap.Fold)
+	if ch != nil {
+		if prefix, ok := srv.modeTypes().PrefixFor(mode[1]); ok {
+			adding := mode[0] == '+'
+			var filtered []string
+			for _, nick := range nicks {
+				modes, ok := ch.UserModes(nick)
+				if ok && strings.IndexByte(modes, prefix) >= 0 == adding {
+					continue
+				}
+				filtered = append(filtered, nick)
+			}
+			nicks = filtered
+		}
+	}
+	return batchModes(channel, mode, nicks, srv.maxModes())
+}
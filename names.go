@@ -0,0 +1,44 @@
+package squirssi
+
+import (
+	"time"
+)
+
+// namesTimeout is how long to wait for the reply to /names before giving up.
+const namesTimeout = 30 * time.Second
+
+// requestNames sends a NAMES query for the channel win is for and prints the
+// reply in win when it arrives.
+func (srv *Server) requestNames(win Window) {
+	channel := win.Title()
+	namesCache.Lock()
+	namesCache.requested[srv.casemap.Fold(channel)] = time.Now()
+	namesCache.Unlock()
+	srv.sendLabeled(win, "NAMES :"+channel)
+}
+
+// namesRequested returns true if the NAMES reply for channel should be
+// printed, forgetting the request. namesCache must be locked.
+func (srv *Server) namesRequested(channel string) bool {
+	k := srv.casemap.Fold(channel)
+	t, ok := namesCache.requested[k]
+	if !ok {
+		return false
+	}
+	delete(namesCache.requested, k)
+	return time.Since(t) < namesTimeout
+}
+
+// chatWidth returns how many characters of a line fit in the chat pane
+// after the timestamp and the given gutter.
+func (srv *Server) chatWidth(padding int) int {
+	srv.mu.RLock()
+	w := srv.chatPane.Inner.Dx()
+	srv.mu.RUnlock()
+	// timestamp, gutter and separator
+	w -= 7 + padding + 2
+	if w < 20 {
+		return 20
+	}
+	return w
+}
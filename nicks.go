@@ -0,0 +1,288 @@
+package squirssi
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"code.dopame.me/veonik/squircy3/irc"
+	"github.com/sirupsen/logrus"
+)
+
+// A NickManager keeps track of the preferred nick and the alternates tried
+// while connecting.
+type NickManager struct {
+	// primary is the nick the user wants, even if it is in use.
+	primary string
+	// tried are the nicks that were in use while registering.
+	tried map[string]struct{}
+	// monitoring is true if the server notifies us when primary is free.
+	monitoring bool
+	// lastAttempt is when we last tried to take primary.
+	lastAttempt time.Time
+
+	mu sync.Mutex
+}
+
+func NewNickManager() *NickManager {
+	return &NickManager{tried: make(map[string]struct{})}
+}
+
+// Reset prepares for a new connection.
+func (nm *NickManager) Reset() {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+	nm.tried = make(map[string]struct{})
+	nm.monitoring = false
+	nm.lastAttempt = time.Time{}
+}
+
+// Primary returns the preferred nick.
+func (nm *NickManager) Primary() string {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+	return nm.primary
+}
+
+// SetPrimary changes the preferred nick.
+func (nm *NickManager) SetPrimary(nick string) {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+	nm.primary = nick
+}
+
+// Monitoring returns true if the server notifies us when the preferred
+// nick is free.
+func (nm *NickManager) Monitoring() bool {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+	return nm.monitoring
+}
+
+// setMonitoring records that the server notifies us when the preferred nick
+// is free.
+func (nm *NickManager) setMonitoring() {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+	nm.monitoring = true
+}
+
+// InUse records that nick was in use while registering, and returns the
+// next alternate to try, if any remain.
+func (nm *NickManager) InUse(nick string, alternates []string, fold func(string) string) (string, bool) {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+	if nm.primary == "" {
+		nm.primary = nick
+	}
+	nm.tried[fold(nick)] = struct{}{}
+	for _, alt := range append([]string{nm.primary}, alternates...) {
+		if _, ok := nm.tried[fold(alt)]; !ok {
+			nm.tried[fold(alt)] = struct{}{}
+			return alt, true
+		}
+	}
+	return "", false
+}
+
+// nickRegainCooldown is the minimum time between attempts to take the
+// primary nick, so that many notifications don't flood the server.
+const nickRegainCooldown = 5 * time.Second
+
+// attempt returns true if enough time has passed since the last attempt to
+// take the primary nick.
+func (nm *NickManager) attempt() bool {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+	if time.Since(nm.lastAttempt) < nickRegainCooldown {
+		return false
+	}
+	nm.lastAttempt = time.Now()
+	return true
+}
+
+// regainNick tries to take the primary nick, first asking NickServ to free
+// it if configured.
+func (srv *Server) regainNick() {
+	primary := srv.nicks.Primary()
+	if primary == "" || srv.isMe(primary) || !srv.nicks.attempt() {
+		return
+	}
+	s := srv.settings.Get()
+	srv.IRCDoAsync(func(conn *irc.Connection) error {
+		switch cmd := strings.ToUpper(s.NickServRegain); cmd {
+		case "GHOST", "RECOVER":
+			if s.NickServPassword == "" {
+				logrus.Warnln("nick:", nickServPasswordEnv, "must be set to use", cmd)
+				break
+			}
+			conn.Privmsg("NickServ", cmd+" "+primary+" "+s.NickServPassword)
+			if cmd == "RECOVER" {
+				// services change our nick for us
+				return nil
+			}
+		}
+		conn.Nick(primary)
+		return nil
+	})
+}
+
+// watchNick starts watching for the primary nick to become available,
+// using MONITOR if the server supports it.
+func (srv *Server) watchNick() {
+	primary := srv.nicks.Primary()
+	if primary == "" || srv.isMe(primary) || srv.settings.Get().NickRegain.Duration <= 0 {
+		return
+	}
+	if srv.settings.Get().NickServRegain != "" {
+		// services can take the nick back right away
+		srv.regainNick()
+	}
+	if !srv.isupport.Has("MONITOR") {
+		return
+	}
+	srv.nicks.setMonitoring()
+	srv.IRCDoAsync(func(conn *irc.Connection) error {
+		conn.SendRawf("MONITOR + %s", primary)
+		return nil
+	})
+}
+
+// startNickRegain periodically checks whether the primary nick is free on
+// servers without MONITOR.
+func (srv *Server) startNickRegain() {
+	t := time.NewTicker(5 * time.Second)
+	defer t.Stop()
+	var last time.Time
+	for {
+		select {
+		case <-srv.done:
+			return
+		case now := <-t.C:
+			interval := srv.settings.Get().NickRegain.Duration
+			if interval <= 0 || now.Sub(last) < interval {
+				continue
+			}
+			primary := srv.nicks.Primary()
+			if srv.CurrentNick() == "" || primary == "" || srv.isMe(primary) {
+				continue
+			}
+			if srv.nicks.Monitoring() {
+				continue
+			}
+			last = now
+			srv.ison([]string{primary})
+		}
+	}
+}
+
+func onIRCRegistered(srv *Server, _ *IRCEvent) {
+	if !srv.setRegistered(true) {
+		// the MOTD was asked for again
+		return
+	}
+	srv.watchNick()
+	srv.watchNotify()
+	if v, ok := srv.isupport.Value("NETWORK"); ok && v != "" {
+		srv.autoJoin()
+		return
+	}
+	// the server did not name its network, go by its hostname instead
+	srv.IRCDoAsync(func(conn *irc.Connection) error {
+		host, _, err := net.SplitHostPort(conn.Server)
+		if err != nil {
+			host = conn.Server
+		}
+		srv.setNetwork(host)
+		srv.autoJoin()
+		return nil
+	})
+}
+
+// handleNickCollisions removes go-ircevent's own handling of nicks in use,
+// which sends another NICK with an underscore added, so that onIRC433 is
+// the only one to pick the next nick to try.
+func handleNickCollisions(conn *irc.Connection) {
+	conn.ClearCallback("433")
+	conn.ClearCallback("437")
+}
+
+func onIRC433(srv *Server, ev *IRCEvent) {
+	// ERR_NICKNAMEINUSE or ERR_UNAVAILRESOURCE
+	if len(ev.Args) < 2 {
+		return
+	}
+	if srv.CurrentNick() != "" {
+		// a nick change failed, we are still registered with the old nick
+		srv.IRCDoAsync(func(conn *irc.Connection) error {
+			srv.setCurrentNick(conn.GetNick())
+			return nil
+		})
+		return
+	}
+	alt, ok := srv.nicks.InUse(ev.Args[1], srv.settings.Get().AltNicks, srv.casemap.Fold)
+	if !ok {
+		// make one up rather than leave registration hanging
+		alt = generatedNick(srv.nicks.Primary())
+	}
+	srv.IRCDoAsync(func(conn *irc.Connection) error {
+		conn.Nick(alt)
+		return nil
+	})
+}
+
+// generatedNick returns nick with random digits added, for when every
+// configured nick is in use. It is kept within 9 characters, the limit in
+// RFC 1459, as the server's own limit is not known while registering.
+func generatedNick(nick string) string {
+	const maxLen, digits = 9, 3
+	if len(nick) > maxLen-digits {
+		nick = nick[:maxLen-digits]
+	}
+	return fmt.Sprintf("%s%03d", nick, rand.Intn(1000))
+}
+
+func onIRC303(srv *Server, ev *IRCEvent) {
+	// RPL_ISON
+	q, ok := srv.isons.pop()
+	if !ok {
+		return
+	}
+	online := make(map[string]struct{})
+	for _, n := range strings.Fields(ev.Message) {
+		online[srv.casemap.Fold(n)] = struct{}{}
+	}
+	primary := srv.nicks.Primary()
+	network := srv.Network()
+	for _, n := range q {
+		_, on := online[srv.casemap.Fold(n)]
+		if srv.notify.Watched(network, n) {
+			if on {
+				srv.notifyOnline(n, "")
+			} else {
+				srv.notifyOffline(n)
+			}
+		}
+		if !on && primary != "" && !srv.isMe(primary) && srv.casemap.Equal(n, primary) {
+			srv.regainNick()
+		}
+	}
+}
+
+func onIRC731(srv *Server, ev *IRCEvent) {
+	// RPL_MONOFFLINE
+	network := srv.Network()
+	primary := srv.nicks.Primary()
+	regain := primary != "" && !srv.isMe(primary) && srv.settings.Get().NickRegain.Duration > 0
+	for _, n := range strings.Split(ev.Message, ",") {
+		if srv.notify.Watched(network, n) {
+			srv.notifyOffline(n)
+		}
+		if regain && srv.casemap.Equal(n, primary) {
+			srv.regainNick()
+		}
+	}
+}
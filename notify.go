@@ -0,0 +1,450 @@
+package squirssi
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"code.dopame.me/veonik/squircy3/irc"
+)
+
+const notifyStoreSection = "notify"
+
+// A NotifyList contains the nicks to watch for on each network, and which
+// of them are online on the current connection.
+type NotifyList struct {
+	networks map[string][]string
+	// online maps the folded nick of watched users that are online to
+	// their nick as sent by the server.
+	online map[string]string
+	// monitoring is true if the server notifies us about watched nicks.
+	monitoring bool
+	// monitored holds the folded nicks in the server's MONITOR list, which
+	// holds at most monitorLimit nicks if it is not negative. Watched nicks
+	// that don't fit are polled with ISON instead.
+	monitored    map[string]struct{}
+	monitorLimit int
+
+	store   *Store
+	casemap *CaseMapper
+
+	mu sync.RWMutex
+}
+
+func NewNotifyList(store *Store, casemap *CaseMapper) *NotifyList {
+	return &NotifyList{
+		networks: make(map[string][]string),
+		online:   make(map[string]string),
+		store:    store,
+		casemap:  casemap,
+	}
+}
+
+// Load restores the notify list from the Store.
+func (nl *NotifyList) Load() error {
+	nl.mu.Lock()
+	defer nl.mu.Unlock()
+	networks := make(map[string][]string)
+	if err := nl.store.Load(notifyStoreSection, &networks); err != nil {
+		return err
+	}
+	nl.networks = networks
+	return nil
+}
+
+// Reset forgets who is online, for a new connection.
+func (nl *NotifyList) Reset() {
+	nl.mu.Lock()
+	defer nl.mu.Unlock()
+	nl.online = make(map[string]string)
+	nl.monitoring = false
+	nl.monitored = nil
+	nl.monitorLimit = 0
+}
+
+func (nl *NotifyList) index(network, nick string) int {
+	for i, n := range nl.networks[network] {
+		if nl.casemap.Equal(n, nick) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Add adds nick to the notify list of network.
+// It returns false if the nick was already in the list.
+func (nl *NotifyList) Add(network, nick string) (bool, error) {
+	nl.mu.Lock()
+	defer nl.mu.Unlock()
+	if nl.index(network, nick) >= 0 {
+		return false, nil
+	}
+	nl.networks[network] = append(nl.networks[network], nick)
+	return true, nl.store.Save(notifyStoreSection, nl.networks)
+}
+
+// Remove removes nick from the notify list of network.
+func (nl *NotifyList) Remove(network, nick string) (bool, error) {
+	nl.mu.Lock()
+	defer nl.mu.Unlock()
+	i := nl.index(network, nick)
+	if i < 0 {
+		return false, nil
+	}
+	l := nl.networks[network]
+	nl.networks[network] = append(l[:i:i], l[i+1:]...)
+	if len(nl.networks[network]) == 0 {
+		delete(nl.networks, network)
+	}
+	delete(nl.online, nl.casemap.Fold(nick))
+	return true, nl.store.Save(notifyStoreSection, nl.networks)
+}
+
+// List returns the nicks watched on network.
+func (nl *NotifyList) List(network string) []string {
+	nl.mu.RLock()
+	defer nl.mu.RUnlock()
+	return append([]string{}, nl.networks[network]...)
+}
+
+// Watched returns true if nick is in the notify list of network.
+func (nl *NotifyList) Watched(network, nick string) bool {
+	nl.mu.RLock()
+	defer nl.mu.RUnlock()
+	return nl.index(network, nick) >= 0
+}
+
+// SetOnline marks nick as online. It returns true if nick was not already online.
+func (nl *NotifyList) SetOnline(nick string) bool {
+	nl.mu.Lock()
+	defer nl.mu.Unlock()
+	k := nl.casemap.Fold(nick)
+	if _, ok := nl.online[k]; ok {
+		return false
+	}
+	nl.online[k] = nick
+	return true
+}
+
+// SetOffline marks nick as offline. It returns true if nick was online.
+func (nl *NotifyList) SetOffline(nick string) bool {
+	nl.mu.Lock()
+	defer nl.mu.Unlock()
+	k := nl.casemap.Fold(nick)
+	if _, ok := nl.online[k]; !ok {
+		return false
+	}
+	delete(nl.online, k)
+	return true
+}
+
+// IsOnline returns true if nick is known to be online.
+func (nl *NotifyList) IsOnline(nick string) bool {
+	nl.mu.RLock()
+	defer nl.mu.RUnlock()
+	_, ok := nl.online[nl.casemap.Fold(nick)]
+	return ok
+}
+
+// Online returns the nicks that are online, sorted.
+func (nl *NotifyList) Online() []string {
+	nl.mu.RLock()
+	defer nl.mu.RUnlock()
+	res := make([]string, 0, len(nl.online))
+	for _, n := range nl.online {
+		res = append(res, n)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Monitoring returns true if the server notifies us about watched nicks.
+func (nl *NotifyList) Monitoring() bool {
+	nl.mu.RLock()
+	defer nl.mu.RUnlock()
+	return nl.monitoring
+}
+
+// setMonitoring records that the server notifies us about watched nicks,
+// watching at most limit of them. A negative limit means there is no limit.
+func (nl *NotifyList) setMonitoring(limit int) {
+	nl.mu.Lock()
+	defer nl.mu.Unlock()
+	nl.monitoring = true
+	nl.monitored = make(map[string]struct{})
+	nl.monitorLimit = limit
+}
+
+// Monitor picks which of nicks to add to the server's MONITOR list without
+// going over its limit. The rest are returned to be polled with ISON.
+func (nl *NotifyList) Monitor(nicks []string) (monitor, poll []string) {
+	nl.mu.Lock()
+	defer nl.mu.Unlock()
+	if !nl.monitoring {
+		return nil, nicks
+	}
+	for _, n := range nicks {
+		k := nl.casemap.Fold(n)
+		if _, ok := nl.monitored[k]; ok {
+			continue
+		}
+		if nl.monitorLimit >= 0 && len(nl.monitored) >= nl.monitorLimit {
+			poll = append(poll, n)
+			continue
+		}
+		nl.monitored[k] = struct{}{}
+		monitor = append(monitor, n)
+	}
+	return monitor, poll
+}
+
+// Unmonitor forgets that nicks are in the server's MONITOR list, returning
+// the ones that were.
+func (nl *NotifyList) Unmonitor(nicks []string) []string {
+	nl.mu.Lock()
+	defer nl.mu.Unlock()
+	var res []string
+	for _, n := range nicks {
+		k := nl.casemap.Fold(n)
+		if _, ok := nl.monitored[k]; ok {
+			delete(nl.monitored, k)
+			res = append(res, n)
+		}
+	}
+	return res
+}
+
+// MonitorFull records that the server's MONITOR list was full when adding
+// nicks, returning the ones that must be polled with ISON instead.
+func (nl *NotifyList) MonitorFull(nicks []string) []string {
+	res := nl.Unmonitor(nicks)
+	nl.mu.Lock()
+	defer nl.mu.Unlock()
+	nl.monitorLimit = len(nl.monitored)
+	return res
+}
+
+// Polled returns the nicks watched on network that are not in the server's
+// MONITOR list.
+func (nl *NotifyList) Polled(network string) []string {
+	nl.mu.RLock()
+	defer nl.mu.RUnlock()
+	var res []string
+	for _, n := range nl.networks[network] {
+		if _, ok := nl.monitored[nl.casemap.Fold(n)]; !ok {
+			res = append(res, n)
+		}
+	}
+	return res
+}
+
+// An IsonManager tracks pending ISON queries.
+// Replies only list the nicks that are online, so the query is needed to
+// know who is offline.
+type IsonManager struct {
+	pending [][]string
+
+	mu sync.Mutex
+}
+
+func NewIsonManager() *IsonManager {
+	return &IsonManager{}
+}
+
+func (im *IsonManager) Reset() {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	im.pending = nil
+}
+
+// send sends an ISON query for nicks. The query is recorded and sent while
+// holding the lock so that pending stays in the order the server replies in.
+func (im *IsonManager) send(conn *irc.Connection, nicks []string) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	im.pending = append(im.pending, nicks)
+	conn.SendRawf("ISON %s", strings.Join(nicks, " "))
+}
+
+func (im *IsonManager) pop() ([]string, bool) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	if len(im.pending) == 0 {
+		return nil, false
+	}
+	q := im.pending[0]
+	im.pending = im.pending[1:]
+	return q, true
+}
+
+// maxNickListLength is the longest list of nicks sent in one ISON or MONITOR line.
+const maxNickListLength = 400
+
+// chunkNicks splits nicks into lists that are at most maxNickListLength
+// long when joined with sep.
+func chunkNicks(nicks []string, sep string) [][]string {
+	var res [][]string
+	var cur []string
+	l := 0
+	for _, n := range nicks {
+		if len(cur) > 0 && l+len(sep)+len(n) > maxNickListLength {
+			res = append(res, cur)
+			cur = nil
+			l = 0
+		}
+		if len(cur) > 0 {
+			l += len(sep)
+		}
+		cur = append(cur, n)
+		l += len(n)
+	}
+	if len(cur) > 0 {
+		res = append(res, cur)
+	}
+	return res
+}
+
+// ison asks the server which of nicks are online.
+func (srv *Server) ison(nicks []string) {
+	for _, c := range chunkNicks(nicks, " ") {
+		c := c
+		srv.IRCDoAsync(func(conn *irc.Connection) error {
+			srv.isons.send(conn, c)
+			return nil
+		})
+	}
+}
+
+// monitor adds or removes nicks from the server's MONITOR list.
+// op is "+" or "-".
+func (srv *Server) monitor(op string, nicks []string) {
+	for _, c := range chunkNicks(nicks, ",") {
+		line := "MONITOR " + op + " " + strings.Join(c, ",")
+		srv.IRCDoAsync(func(conn *irc.Connection) error {
+			conn.SendRaw(line)
+			return nil
+		})
+	}
+}
+
+// watchNotify starts watching the nicks in the notify list, using MONITOR
+// for as many as the server allows.
+func (srv *Server) watchNotify() {
+	if v, ok := srv.isupport.Value("MONITOR"); ok {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			limit = -1
+		} else if srv.nicks.Monitoring() {
+			// one is taken to regain the preferred nick
+			limit--
+		}
+		srv.notify.setMonitoring(limit)
+	}
+	srv.watchNotifyNicks(srv.notify.List(srv.Network()))
+}
+
+// watchNotifyNicks starts watching nicks, adding them to the server's
+// MONITOR list if they fit and polling the rest with ISON.
+func (srv *Server) watchNotifyNicks(nicks []string) {
+	monitor, poll := srv.notify.Monitor(nicks)
+	if len(monitor) > 0 {
+		srv.monitor("+", monitor)
+	}
+	if len(poll) > 0 {
+		srv.ison(poll)
+	}
+}
+
+// notifyOnline records that a watched nick is online, printing a message
+// if they were not already.
+func (srv *Server) notifyOnline(nick, host string) {
+	if !srv.notify.SetOnline(nick) {
+		return
+	}
+	WriteNotify(srv.windows.Index(0), nick, host, true)
+	srv.events.Emit("ui.DIRTY", nil)
+}
+
+// notifyOffline records that a watched nick is offline, printing a message
+// if they were online.
+func (srv *Server) notifyOffline(nick string) {
+	if !srv.notify.SetOffline(nick) {
+		return
+	}
+	WriteNotify(srv.windows.Index(0), nick, "", false)
+	srv.events.Emit("ui.DIRTY", nil)
+}
+
+// startNotifyPoll periodically checks who in the notify list is online,
+// for nicks that are not in the server's MONITOR list.
+func (srv *Server) startNotifyPoll() {
+	t := time.NewTicker(5 * time.Second)
+	defer t.Stop()
+	var last time.Time
+	for {
+		select {
+		case <-srv.done:
+			return
+		case now := <-t.C:
+			interval := srv.settings.Get().NotifyInterval.Duration
+			if interval <= 0 || now.Sub(last) < interval {
+				continue
+			}
+			if srv.CurrentNick() == "" {
+				continue
+			}
+			nicks := srv.notify.Polled(srv.Network())
+			if len(nicks) == 0 {
+				continue
+			}
+			last = now
+			srv.ison(nicks)
+		}
+	}
+}
+
+func onIRC730(srv *Server, ev *IRCEvent) {
+	// RPL_MONONLINE
+	network := srv.Network()
+	for _, t := range strings.Split(ev.Message, ",") {
+		nick, host := t, ""
+		if i := strings.IndexByte(t, '!'); i >= 0 {
+			nick, host = t[:i], t[i+1:]
+		}
+		if srv.notify.Watched(network, nick) {
+			srv.notifyOnline(nick, host)
+		}
+	}
+}
+
+func onIRC734(srv *Server, ev *IRCEvent) {
+	// ERR_MONLISTFULL
+	if len(ev.Args) < 3 {
+		return
+	}
+	if poll := srv.notify.MonitorFull(strings.Split(ev.Args[2], ",")); len(poll) > 0 {
+		srv.ison(poll)
+	}
+}
+
+// notifyRows provides the rows of the notify pane.
+type notifyRows []string
+
+func (r notifyRows) Len() int {
+	return len(r)
+}
+
+func (r notifyRows) Rows(start, end int) []string {
+	if start < 0 {
+		start = 0
+	}
+	if end > len(r) {
+		end = len(r)
+	}
+	if start >= end {
+		return nil
+	}
+	return append([]string{}, r[start:end]...)
+}
@@ -0,0 +1,283 @@
+package squirssi
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A numericRoute determines which window a numeric reply is printed in.
+type numericRoute int
+
+const (
+	// routeStatus prints the reply in the status window.
+	routeStatus numericRoute = iota
+	// routeActive prints the reply in the active window.
+	routeActive
+	// routeTarget prints the reply in the window named by the first
+	// parameter, or the active window if there is no such window.
+	routeTarget
+)
+
+// A Numeric describes how a numeric reply is displayed.
+type Numeric struct {
+	// Name is the name of the numeric, such as ERR_NOSUCHNICK.
+	Name  string
+	Route numericRoute
+	// Error is true for error replies.
+	Error bool
+	// Format is the text printed for the reply. {1}, {2}, etc are replaced
+	// with parameters after our nick, {msg} with the last parameter, and
+	// {*} with all parameters after our nick. An empty Format is the same
+	// as {*}.
+	Format string
+}
+
+// numerics is the catalog of numeric replies that are not handled elsewhere.
+var numerics = map[string]Numeric{
+	// connection registration
+	"001": {Name: "RPL_WELCOME", Format: "{msg}"},
+	"002": {Name: "RPL_YOURHOST", Format: "{msg}"},
+	"003": {Name: "RPL_CREATED", Format: "{msg}"},
+	"004": {Name: "RPL_MYINFO", Format: "Server {1} running {2}, user modes {3}, channel modes {4}"},
+	"010": {Name: "RPL_BOUNCE", Format: "Try server {1}, port {2}"},
+	"042": {Name: "RPL_YOURID", Format: "Your unique ID is {1}"},
+
+	// stats, trace and lusers
+	"200": {Name: "RPL_TRACELINK"},
+	"201": {Name: "RPL_TRACECONNECTING"},
+	"202": {Name: "RPL_TRACEHANDSHAKE"},
+	"203": {Name: "RPL_TRACEUNKNOWN"},
+	"204": {Name: "RPL_TRACEOPERATOR"},
+	"205": {Name: "RPL_TRACEUSER"},
+	"206": {Name: "RPL_TRACESERVER"},
+	"208": {Name: "RPL_TRACENEWTYPE"},
+	"209": {Name: "RPL_TRACECLASS"},
+	"211": {Name: "RPL_STATSLINKINFO"},
+	"212": {Name: "RPL_STATSCOMMANDS", Format: "{1}: used {2} times"},
+	"213": {Name: "RPL_STATSCLINE"},
+	"215": {Name: "RPL_STATSILINE"},
+	"216": {Name: "RPL_STATSKLINE"},
+	"218": {Name: "RPL_STATSYLINE"},
+	"219": {Name: "RPL_ENDOFSTATS", Format: "End of stats {1}"},
+	"221": {Name: "RPL_UMODEIS", Format: "Your user modes are {1}"},
+	"242": {Name: "RPL_STATSUPTIME", Format: "{msg}"},
+	"243": {Name: "RPL_STATSOLINE"},
+	"250": {Name: "RPL_STATSCONN", Format: "{msg}"},
+	"251": {Name: "RPL_LUSERCLIENT", Format: "{msg}"},
+	"252": {Name: "RPL_LUSEROP", Format: "{1} {msg}"},
+	"253": {Name: "RPL_LUSERUNKNOWN", Format: "{1} {msg}"},
+	"254": {Name: "RPL_LUSERCHANNELS", Format: "{1} {msg}"},
+	"255": {Name: "RPL_LUSERME", Format: "{msg}"},
+	"256": {Name: "RPL_ADMINME", Format: "{msg}"},
+	"257": {Name: "RPL_ADMINLOC1", Format: "{msg}"},
+	"258": {Name: "RPL_ADMINLOC2", Format: "{msg}"},
+	"259": {Name: "RPL_ADMINEMAIL", Format: "{msg}"},
+	"261": {Name: "RPL_TRACELOG"},
+	"262": {Name: "RPL_TRACEEND"},
+	"263": {Name: "RPL_TRYAGAIN", Route: routeActive, Error: true, Format: "{1}: server is busy, try again later"},
+	"265": {Name: "RPL_LOCALUSERS", Format: "{msg}"},
+	"266": {Name: "RPL_GLOBALUSERS", Format: "{msg}"},
+	"276": {Name: "RPL_WHOISCERTFP", Route: routeTarget, Format: "{1} {msg}"},
+
+	// command replies
+	"341": {Name: "RPL_INVITING", Route: routeActive, Format: "Inviting {1} to {2}"},
+	"342": {Name: "RPL_SUMMONING", Route: routeActive, Format: "Summoning {1}"},
+	"351": {Name: "RPL_VERSION", Format: "Server {2} is running {1} {msg}"},
+	"364": {Name: "RPL_LINKS", Format: "{1} {2} {msg}"},
+	"365": {Name: "RPL_ENDOFLINKS", Format: "End of links"},
+	"371": {Name: "RPL_INFO", Format: "{msg}"},
+	"374": {Name: "RPL_ENDOFINFO", Format: "End of info"},
+	"375": {Name: "RPL_MOTDSTART", Format: "{msg}"},
+	"372": {Name: "RPL_MOTD", Format: "{msg}"},
+	"376": {Name: "RPL_ENDOFMOTD", Format: "{msg}"},
+	"381": {Name: "RPL_YOUREOPER", Format: "You are now an IRC operator"},
+	"382": {Name: "RPL_REHASHING", Format: "Rehashing {1}"},
+	"391": {Name: "RPL_TIME", Format: "Time on {1} is {msg}"},
+	"396": {Name: "RPL_HOSTHIDDEN", Format: "{1} is now your displayed host"},
+
+	// errors
+	"400": {Name: "ERR_UNKNOWNERROR", Route: routeActive, Error: true, Format: "{*}"},
+	"401": {Name: "ERR_NOSUCHNICK", Route: routeTarget, Error: true, Format: "No such nick or channel: {1}"},
+	"402": {Name: "ERR_NOSUCHSERVER", Route: routeActive, Error: true, Format: "No such server: {1}"},
+	"403": {Name: "ERR_NOSUCHCHANNEL", Route: routeTarget, Error: true, Format: "No such channel: {1}"},
+	"404": {Name: "ERR_CANNOTSENDTOCHAN", Route: routeTarget, Error: true, Format: "Cannot send to {1}: {msg}"},
+	"405": {Name: "ERR_TOOMANYCHANNELS", Route: routeActive, Error: true, Format: "Cannot join {1}: you have joined too many channels"},
+	"406": {Name: "ERR_WASNOSUCHNICK", Route: routeTarget, Error: true, Format: "There was no such nick {1}"},
+	"407": {Name: "ERR_TOOMANYTARGETS", Route: routeActive, Error: true, Format: "{1}: {msg}"},
+	"408": {Name: "ERR_NOSUCHSERVICE", Route: routeActive, Error: true, Format: "No such service: {1}"},
+	"409": {Name: "ERR_NOORIGIN", Route: routeStatus, Error: true, Format: "{msg}"},
+	"411": {Name: "ERR_NORECIPIENT", Route: routeActive, Error: true, Format: "No recipient given"},
+	"412": {Name: "ERR_NOTEXTTOSEND", Route: routeActive, Error: true, Format: "No text to send"},
+	"413": {Name: "ERR_NOTOPLEVEL", Route: routeActive, Error: true, Format: "No toplevel domain specified in {1}"},
+	"414": {Name: "ERR_WILDTOPLEVEL", Route: routeActive, Error: true, Format: "Wildcard in toplevel domain {1}"},
+	"415": {Name: "ERR_BADMASK", Route: routeActive, Error: true, Format: "Bad server or host mask {1}"},
+	"416": {Name: "ERR_TOOMANYMATCHES", Route: routeActive, Error: true, Format: "{1}: too many matches"},
+	"417": {Name: "ERR_INPUTTOOLONG", Route: routeActive, Error: true, Format: "Your message was too long"},
+	"421": {Name: "ERR_UNKNOWNCOMMAND", Route: routeActive, Error: true, Format: "Unknown command: {1}"},
+	"422": {Name: "ERR_NOMOTD", Format: "{msg}"},
+	"423": {Name: "ERR_NOADMININFO", Route: routeActive, Error: true, Format: "No administrative info available for {1}"},
+	"424": {Name: "ERR_FILEERROR", Route: routeActive, Error: true, Format: "{msg}"},
+	"431": {Name: "ERR_NONICKNAMEGIVEN", Route: routeActive, Error: true, Format: "No nickname given"},
+	"432": {Name: "ERR_ERRONEUSNICKNAME", Route: routeActive, Error: true, Format: "Invalid nickname: {1}"},
+	"433": {Name: "ERR_NICKNAMEINUSE", Route: routeActive, Error: true, Format: "Nickname {1} is already in use"},
+	"435": {Name: "ERR_BANNICKCHANGE", Route: routeActive, Error: true, Format: "Cannot change nickname while banned on {2}"},
+	"436": {Name: "ERR_NICKCOLLISION", Route: routeStatus, Error: true, Format: "Nickname collision on {1}"},
+	"437": {Name: "ERR_UNAVAILRESOURCE", Route: routeActive, Error: true, Format: "{1} is temporarily unavailable"},
+	"438": {Name: "ERR_NICKTOOFAST", Route: routeActive, Error: true, Format: "Nick changed too fast, wait and try again"},
+	"441": {Name: "ERR_USERNOTINCHANNEL", Route: routeActive, Error: true, Format: "{1} is not on {2}"},
+	"442": {Name: "ERR_NOTONCHANNEL", Route: routeTarget, Error: true, Format: "You are not on {1}"},
+	"443": {Name: "ERR_USERONCHANNEL", Route: routeActive, Error: true, Format: "{1} is already on {2}"},
+	"444": {Name: "ERR_NOLOGIN", Route: routeActive, Error: true, Format: "{1} is not logged in"},
+	"445": {Name: "ERR_SUMMONDISABLED", Route: routeActive, Error: true, Format: "SUMMON has been disabled"},
+	"446": {Name: "ERR_USERSDISABLED", Route: routeActive, Error: true, Format: "USERS has been disabled"},
+	"451": {Name: "ERR_NOTREGISTERED", Route: routeStatus, Error: true, Format: "You have not registered"},
+	"461": {Name: "ERR_NEEDMOREPARAMS", Route: routeActive, Error: true, Format: "Not enough parameters for {1}"},
+	"462": {Name: "ERR_ALREADYREGISTERED", Route: routeActive, Error: true, Format: "You are already registered"},
+	"463": {Name: "ERR_NOPERMFORHOST", Route: routeStatus, Error: true, Format: "Your host is not permitted to connect"},
+	"464": {Name: "ERR_PASSWDMISMATCH", Route: routeStatus, Error: true, Format: "Incorrect server password"},
+	"465": {Name: "ERR_YOUREBANNEDCREEP", Route: routeStatus, Error: true, Format: "You are banned from this server: {msg}"},
+	"466": {Name: "ERR_YOUWILLBEBANNED", Route: routeStatus, Error: true, Format: "You will soon be banned from this server"},
+	"467": {Name: "ERR_KEYSET", Route: routeTarget, Error: true, Format: "Channel key for {1} is already set"},
+	"476": {Name: "ERR_BADCHANMASK", Route: routeActive, Error: true, Format: "Invalid channel name: {1}"},
+	"478": {Name: "ERR_BANLISTFULL", Route: routeTarget, Error: true, Format: "The list for {1} is full, cannot add {2}"},
+	"481": {Name: "ERR_NOPRIVILEGES", Route: routeActive, Error: true, Format: "Permission denied, you are not an IRC operator"},
+	"482": {Name: "ERR_CHANOPRIVSNEEDED", Route: routeTarget, Error: true, Format: "You are not a channel operator on {1}"},
+	"483": {Name: "ERR_CANTKILLSERVER", Route: routeActive, Error: true, Format: "You cannot kill a server"},
+	"484": {Name: "ERR_RESTRICTED", Route: routeActive, Error: true, Format: "Your connection is restricted"},
+	"485": {Name: "ERR_UNIQOPPRIVSNEEDED", Route: routeActive, Error: true, Format: "You are not the original channel operator"},
+	"489": {Name: "ERR_SECUREONLYCHAN", Route: routeActive, Error: true, Format: "Cannot join {1}: a secure connection is required"},
+	"491": {Name: "ERR_NOOPERHOST", Route: routeActive, Error: true, Format: "No O-lines for your host"},
+	"501": {Name: "ERR_UMODEUNKNOWNFLAG", Route: routeActive, Error: true, Format: "Unknown user mode flag"},
+	"502": {Name: "ERR_USERSDONTMATCH", Route: routeActive, Error: true, Format: "Cannot change modes for other users"},
+	"524": {Name: "ERR_HELPNOTFOUND", Route: routeActive, Error: true, Format: "No help available for {1}"},
+	"525": {Name: "ERR_INVALIDKEY", Route: routeTarget, Error: true, Format: "Invalid channel key for {1}"},
+
+	// common ircd extensions
+	"704": {Name: "RPL_HELPSTART", Route: routeActive, Format: "{msg}"},
+	"705": {Name: "RPL_HELPTXT", Route: routeActive, Format: "{msg}"},
+	"706": {Name: "RPL_ENDOFHELP", Route: routeActive, Format: "{msg}"},
+	"710": {Name: "RPL_KNOCK", Route: routeTarget, Format: "{2} is knocking on {1}: {msg}"},
+	"711": {Name: "RPL_KNOCKDLVR", Route: routeActive, Format: "Your knock was delivered to {1}"},
+	"712": {Name: "ERR_TOOMANYKNOCK", Route: routeActive, Error: true, Format: "Too many knocks on {1}"},
+	"713": {Name: "ERR_CHANOPEN", Route: routeActive, Error: true, Format: "{1} is open, just join it"},
+	"714": {Name: "ERR_KNOCKONCHAN", Route: routeActive, Error: true, Format: "You are already on {1}"},
+	"716": {Name: "ERR_TARGUMODEG", Route: routeTarget, Error: true, Format: "{1} only accepts messages from users they allow"},
+	"717": {Name: "RPL_TARGNOTIFY", Route: routeTarget, Format: "{1} has been told you messaged them"},
+	"718": {Name: "RPL_UMODEGMSG", Route: routeStatus, Format: "{1} ({2}) wants to message you"},
+	"723": {Name: "ERR_NOPRIVS", Route: routeActive, Error: true, Format: "Permission denied, you need the {1} privilege"},
+	"732": {Name: "RPL_MONLIST", Route: routeActive, Format: "Monitoring {msg}"},
+	"733": {Name: "RPL_ENDOFMONLIST", Route: routeActive, Format: "End of monitor list"},
+	"734": {Name: "ERR_MONLISTFULL", Route: routeActive, Error: true, Format: "Monitor list is full, cannot add {2}"},
+	"742": {Name: "ERR_MLOCKRESTRICTED", Route: routeTarget, Error: true, Format: "Cannot change mode {2} on {1}, it is locked by services"},
+
+	// SASL
+	"900": {Name: "RPL_LOGGEDIN", Format: "You are now logged in as {3}"},
+	"901": {Name: "RPL_LOGGEDOUT", Format: "You are now logged out"},
+	"902": {Name: "ERR_NICKLOCKED", Error: true, Format: "SASL authentication failed, your nick is locked"},
+	"903": {Name: "RPL_SASLSUCCESS", Format: "SASL authentication successful"},
+	"904": {Name: "ERR_SASLFAIL", Error: true, Format: "SASL authentication failed"},
+	"905": {Name: "ERR_SASLTOOLONG", Error: true, Format: "SASL authentication failed, message too long"},
+	"906": {Name: "ERR_SASLABORTED", Error: true, Format: "SASL authentication aborted"},
+	"907": {Name: "ERR_SASLALREADY", Error: true, Format: "You are already authenticated"},
+	"908": {Name: "RPL_SASLMECHS", Format: "Available SASL mechanisms: {1}"},
+}
+
+var numericParam = regexp.MustCompile(`\{(\d+|msg|\*)\}`)
+
+// Text returns the human readable text of a numeric reply.
+// args are the reply's parameters, starting with our nick.
+func (n Numeric) Text(args []string) string {
+	if len(args) > 0 {
+		args = args[1:]
+	}
+	f := n.Format
+	if f == "" {
+		f = "{*}"
+	}
+	return numericParam.ReplaceAllStringFunc(f, func(m string) string {
+		switch p := m[1 : len(m)-1]; p {
+		case "*":
+			return strings.Join(args, " ")
+		case "msg":
+			if len(args) == 0 {
+				return ""
+			}
+			return args[len(args)-1]
+		default:
+			i, _ := strconv.Atoi(p)
+			if i < 1 || i > len(args) {
+				return ""
+			}
+			return args[i-1]
+		}
+	})
+}
+
+// isNumeric returns true if code is a three digit numeric reply.
+func isNumeric(code string) bool {
+	if len(code) != 3 {
+		return false
+	}
+	for i := 0; i < 3; i++ {
+		if code[i] < '0' || code[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// numericWindow returns the window a numeric reply should be printed in.
+// Replies to labeled commands are printed where the command was sent.
+func (srv *Server) numericWindow(n Numeric, ev *IRCEvent) Window {
+	if win, ok := srv.labeledWindow(ev); ok {
+		return win
+	}
+	args := ev.Args
+	switch n.Route {
+	case routeActive:
+		return srv.windows.Active()
+	case routeTarget:
+		if len(args) > 1 {
+			if win := srv.windows.Named(args[1]); win != nil {
+				return win
+			}
+		}
+		return srv.windows.Active()
+	}
+	return srv.windows.Index(0)
+}
+
+func onIRCNumeric(srv *Server, ev *IRCEvent) {
+	n, ok := numerics[ev.Code]
+	if !ok {
+		return
+	}
+	win := srv.numericWindow(n, ev)
+	if win == nil {
+		win = srv.windows.Index(0)
+	}
+	WriteNumeric(win, n, n.Text(ev.Args))
+	if n.Error {
+		srv.failSendFor(ev)
+	}
+	srv.labelReplied(ev)
+}
+
+// onIRCUnknownNumeric shows numeric replies that are not in the catalog and
+// have no handler of their own in the status window.
+func onIRCUnknownNumeric(srv *Server, ev *IRCEvent) {
+	if !isNumeric(ev.Code) {
+		return
+	}
+	args := ev.Args
+	if len(args) > 0 {
+		args = args[1:]
+	}
+	win, ok := srv.labeledWindow(ev)
+	if !ok {
+		win = srv.windows.Index(0)
+	}
+	WriteUnknownNumeric(win, ev.Code, strings.Join(args, " "))
+	srv.labelReplied(ev)
+}
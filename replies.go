@@ -0,0 +1,96 @@
+package squirssi
+
+import (
+	"time"
+
+	"code.dopame.me/veonik/squircy3/irc"
+)
+
+// windowMessage returns the message in win with the given ID.
+func windowMessage(win Window, id string) (MessageInfo, bool) {
+	if mw, ok := win.(interface {
+		Message(id string) (MessageInfo, bool)
+	}); ok {
+		return mw.Message(id)
+	}
+	return MessageInfo{}, false
+}
+
+// selectedMessage returns the message at the bottom of win when scrolled
+// up, or the latest message.
+func selectedMessage(win Window) (MessageInfo, bool) {
+	mw, ok := win.(interface {
+		MessageAt(line int) (MessageInfo, bool)
+	})
+	if !ok {
+		return MessageInfo{}, false
+	}
+	line := -1
+	if !win.AutoScroll() {
+		line = win.CurrentLine()
+	}
+	return mw.MessageAt(line)
+}
+
+// identifyMessage records the ID of a message we sent, written to win
+// with the given token.
+func identifyMessage(win Window, token string, info MessageInfo) {
+	if info.ID == "" {
+		return
+	}
+	if mw, ok := win.(interface {
+		Identify(token string, info MessageInfo) bool
+	}); ok {
+		mw.Identify(token, info)
+	}
+}
+
+// addReaction shows nick's reaction to the message with the given ID in win.
+func addReaction(win Window, id, nick, reaction string) {
+	rw, ok := win.(interface {
+		React(id, nick, reaction string, render func(rs []Reaction) string) bool
+	})
+	if !ok {
+		return
+	}
+	rw.React(id, nick, reaction, func(rs []Reaction) string {
+		return reactionsLine(win, rs)
+	})
+}
+
+// sendReaction reacts to the message with the given ID in win.
+func (srv *Server) sendReaction(win Window, id, reaction string) {
+	tags := map[string]string{"+draft/react": reaction, "+draft/reply": id}
+	target := win.Title()
+	srv.IRCDoAsync(func(conn *irc.Connection) error {
+		conn.SendRaw(formatTags(tags) + "TAGMSG " + target)
+		return nil
+	})
+	if !srv.caps.Enabled("echo-message") {
+		addReaction(win, id, srv.CurrentNick(), reaction)
+	}
+}
+
+// onIRCReaction handles a TAGMSG reacting to a message.
+func onIRCReaction(srv *Server, ev *IRCEvent, target string) {
+	reaction := ev.Tags["+draft/react"]
+	id := ev.Tags["+draft/reply"]
+	if reaction == "" || id == "" || isIgnored(srv, ev, target, IgnoreMsgs) {
+		return
+	}
+	win := srv.windows.Named(target)
+	if win == nil {
+		return
+	}
+	addReaction(win, id, ev.Nick, reaction)
+}
+
+// replyTo returns win set up to show a message we send, with a quote of
+// parent if it is a reply.
+func replyTo(win Window, parent MessageInfo) messageWindow {
+	mw := messageWindow{Window: win, at: time.Now()}
+	if parent.ID != "" {
+		mw.quote = replyQuoteLine(win, parent, true)
+	}
+	return mw
+}
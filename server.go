@@ -3,6 +3,7 @@ package squirssi
 
 import (
 	"fmt"
+	"path/filepath"
 	"sync"
 	"time"
 
@@ -30,27 +31,61 @@ type Server struct {
 	inputTextBox *widget.ModedTextInput
 	chatPane     *widget.ChatPane
 	userListPane *widget.UserList
+	notifyPane   *widget.UserList
 
 	events *event.Dispatcher
 	irc    *irc.Manager
 	vm     *vm.VM
 
 	currentNick string
+	network     string
+	casemap     *CaseMapper
+	// registered is true once registration with the server has finished.
+	registered bool
 
 	windows *WindowManager
 	history *HistoryManager
 	tabber  *TabCompleter
 
+	store    *Store
+	settings *SettingsManager
+	ignores  *IgnoreList
+	topics   *TopicHistory
+	channels *ChannelConfigs
+	keys     *ChannelKeys
+	away     *AwayManager
+
+	isupport    *ISupport
+	caps        *CapManager
+	nicks       *NickManager
+	users       *UserRegistry
+	whos        *WhoManager
+	whoises     *WhoisManager
+	notify      *NotifyList
+	isons       *IsonManager
+	echoes      *EchoManager
+	labels      *LabelManager
+	userhosts   *UserhostManager
+	modeLists   *ModeListManager
+	chathistory *ChatHistoryManager
+	typing      *TypingManager
+	tlsm        *TLSManager
+
 	mu   sync.RWMutex
 	done chan struct{}
 
 	interrupt Interrupter
 
+	// logDir is where channel logs are written.
+	logDir string
+
 	debounce bool
 }
 
 // NewServer creates a new server.
 func NewServer(ev *event.Dispatcher, irc *irc.Manager, jsvm *vm.VM) (*Server, error) {
+	store := NewStore()
+	casemap := NewCaseMapper()
 	srv := &Server{
 		Logger:        logrus.StandardLogger(),
 		outputLogHook: newLogFileWriterHook(),
@@ -59,10 +94,36 @@ func NewServer(ev *event.Dispatcher, irc *irc.Manager, jsvm *vm.VM) (*Server, er
 		irc:    irc,
 		vm:     jsvm,
 
-		windows: NewWindowManager(ev),
+		casemap: casemap,
+
+		windows: NewWindowManager(ev, casemap),
 		history: NewHistoryManager(),
 		tabber:  NewTabCompleter(),
 
+		store:    store,
+		settings: NewSettingsManager(store),
+		ignores:  NewIgnoreList(store, casemap),
+		topics:   NewTopicHistory(store, casemap),
+		channels: NewChannelConfigs(store, casemap),
+		keys:     NewChannelKeys(casemap),
+		away:     NewAwayManager(casemap),
+
+		isupport:    NewISupport(),
+		caps:        NewCapManager(),
+		nicks:       NewNickManager(),
+		users:       NewUserRegistry(casemap),
+		whos:        NewWhoManager(casemap),
+		whoises:     NewWhoisManager(casemap),
+		notify:      NewNotifyList(store, casemap),
+		isons:       NewIsonManager(),
+		echoes:      NewEchoManager(),
+		labels:      NewLabelManager(),
+		userhosts:   NewUserhostManager(),
+		modeLists:   NewModeListManager(casemap),
+		chathistory: NewChatHistoryManager(casemap),
+		typing:      NewTypingManager(casemap),
+		tlsm:        NewTLSManager(),
+
 		done: make(chan struct{}),
 	}
 	srv.initUI()
@@ -81,6 +142,31 @@ func (srv *Server) OnInterrupt(fn Interrupter) *Server {
 	return srv
 }
 
+// OpenStore loads persisted state from the given file.
+// Changes made during the session are saved to the same file.
+func (srv *Server) OpenStore(path string) error {
+	if err := srv.store.Open(path); err != nil {
+		return err
+	}
+	if err := srv.settings.Load(); err != nil {
+		return err
+	}
+	if err := srv.ignores.Load(); err != nil {
+		return err
+	}
+	if err := srv.topics.Load(); err != nil {
+		return err
+	}
+	if err := srv.channels.Load(); err != nil {
+		return err
+	}
+	if err := srv.notify.Load(); err != nil {
+		return err
+	}
+	srv.logDir = filepath.Join(filepath.Dir(path), "logs")
+	return nil
+}
+
 func (srv *Server) IRCDoAsync(fn func(conn *irc.Connection) error) {
 	go func() {
 		err := srv.irc.Do(fn)
@@ -96,12 +182,27 @@ func (srv *Server) CurrentNick() string {
 	return srv.currentNick
 }
 
+// isMe returns true if nick is the current nick.
+func (srv *Server) isMe(nick string) bool {
+	return srv.casemap.Equal(nick, srv.CurrentNick())
+}
+
 func (srv *Server) setCurrentNick(newNick string) {
 	srv.mu.Lock()
 	defer srv.mu.Unlock()
 	srv.currentNick = newNick
 }
 
+// setRegistered records that registration with the server has finished,
+// returning false if it already had on this connection.
+func (srv *Server) setRegistered(registered bool) bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	changed := srv.registered != registered
+	srv.registered = registered
+	return changed
+}
+
 func (srv *Server) initUI() {
 	ui.StyleParserColorMap["gray"] = colors.Grey35
 	ui.StyleParserColorMap["grey"] = colors.Grey35
@@ -114,7 +215,6 @@ func (srv *Server) initUI() {
 	ui.StyleParserColorMap["orange"] = colors.Orange1
 
 	srv.userListPane = widget.NewUserList()
-	srv.userListPane.Rows = []string{}
 	srv.userListPane.Border = true
 	srv.userListPane.BorderRight = false
 	srv.userListPane.BorderLeft = false
@@ -125,6 +225,16 @@ func (srv *Server) initUI() {
 	srv.userListPane.PaddingRight = 0
 	srv.userListPane.TitleStyle.Fg = colors.Grey100
 
+	srv.notifyPane = widget.NewUserList()
+	srv.notifyPane.Border = true
+	srv.notifyPane.BorderRight = false
+	srv.notifyPane.BorderLeft = false
+	srv.notifyPane.BorderTop = true
+	srv.notifyPane.BorderBottom = true
+	srv.notifyPane.BorderStyle.Fg = colors.Grey42
+	srv.notifyPane.PaddingRight = 0
+	srv.notifyPane.TitleStyle.Fg = colors.Grey100
+
 	srv.chatPane = widget.NewChatPane()
 	srv.chatPane.Rows = []string{}
 	srv.chatPane.BorderStyle.Fg = colors.DodgerBlue1
@@ -146,6 +256,7 @@ func (srv *Server) initUI() {
 	srv.statusBar.BorderRight = false
 	srv.statusBar.BorderBottom = false
 	srv.statusBar.BorderStyle.Fg = colors.DodgerBlue1
+	srv.statusBar.StatusStyle = ui.NewStyle(colors.Grey100, colors.Grey35)
 
 	srv.inputTextBox = widget.NewModedTextInput()
 	srv.inputTextBox.Border = false
@@ -178,7 +289,20 @@ func (srv *Server) Update() {
 	}
 	win.Touch()
 	srv.statusBar.TabNames, srv.statusBar.TabsWithActivity = srv.windows.TabNames()
+	srv.statusBar.StatusText = ""
+	if typing := typingText(srv.typing.Typing(win.Title())); typing != "" {
+		srv.statusBar.StatusText = " " + typing + " "
+	}
+	if away, _ := srv.away.Away(); away {
+		srv.statusBar.StatusText += " away "
+	}
 	srv.chatPane.SelectedRow = win.CurrentLine()
+	if _, ok := win.(*ChannelList); !ok {
+		// highlight the line targeted by /reply and /react when scrolled up
+		srv.chatPane.HighlightSelected = !win.AutoScroll()
+	} else {
+		srv.chatPane.HighlightSelected = false
+	}
 	srv.chatPane.Rows = win.Lines()
 	srv.chatPane.Title = win.Title()
 
@@ -194,22 +318,28 @@ func (srv *Server) Update() {
 		srv.chatPane.ModeText = srv.currentNick
 	}
 	srv.mainWindow.Items = nil
+	settings := srv.settings.Get()
+	var cols []interface{}
+	chatWidth := 1.0
 	if v, ok := win.(WindowWithUserList); ok {
-		srv.userListPane.Rows = v.UserList()
+		srv.userListPane.Source = v.UserList(settings.RankStyles)
+		n := srv.userListPane.Source.Len()
 		suff := "s"
-		if len(srv.userListPane.Rows) == 1 {
+		if n == 1 {
 			suff = ""
 		}
-		srv.userListPane.Title = fmt.Sprintf("%d user%s", len(srv.userListPane.Rows), suff)
-		srv.mainWindow.Set(
-			ui.NewCol(.85, srv.chatPane),
-			ui.NewCol(.15, srv.userListPane),
-		)
-	} else {
-		srv.mainWindow.Set(
-			ui.NewCol(1, srv.chatPane),
-		)
+		srv.userListPane.Title = fmt.Sprintf("%d user%s", n, suff)
+		cols = append(cols, ui.NewCol(.15, srv.userListPane))
+		chatWidth -= .15
+	}
+	if settings.NotifyPane {
+		online := srv.notify.Online()
+		srv.notifyPane.Source = notifyRows(online)
+		srv.notifyPane.Title = fmt.Sprintf("%d online", len(online))
+		cols = append(cols, ui.NewCol(.15, srv.notifyPane))
+		chatWidth -= .15
 	}
+	srv.mainWindow.Set(append([]interface{}{ui.NewCol(chatWidth, srv.chatPane)}, cols...)...)
 }
 
 type screenElement int
@@ -295,6 +425,10 @@ func (srv *Server) Start() error {
 	srv.Render()
 
 	go srv.startUIEventLoop()
+	go srv.startAutoAway()
+	go srv.startNickRegain()
+	go srv.startNotifyPoll()
+	go srv.startTypingTimer()
 
 	return nil
 }
@@ -0,0 +1,225 @@
+package squirssi
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Duration is a time.Duration that is stored as a human readable string.
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+// Settings contains user preferences.
+// Settings are persisted in the Store and can be changed with /set.
+type Settings struct {
+	// AutoAway marks the user as away after this long without any input.
+	// Zero disables auto-away.
+	AutoAway Duration `json:"auto_away"`
+	// AutoAwayMessage is the away reason used when auto-away kicks in.
+	AutoAwayMessage string `json:"auto_away_message"`
+
+	// BanMask lists the parts of a user's hostmask kept when banning by nick.
+	// Any of "nick", "user", "host" and "domain".
+	BanMask []string `json:"ban_mask"`
+
+	// AltNicks are tried in order when the nick is in use while connecting.
+	AltNicks []string `json:"alt_nicks"`
+	// NickRegain is how often to check if the preferred nick is free when it
+	// was in use. MONITOR is used instead when the server supports it.
+	// Zero disables regaining the preferred nick.
+	NickRegain Duration `json:"nick_regain"`
+	// NickServRegain is "ghost" or "recover" to have NickServ free the
+	// preferred nick, or empty to wait for it to become free.
+	NickServRegain string `json:"nickserv_regain"`
+	// NickServPassword is sent to NickServ with GHOST and RECOVER. It is
+	// read from the environment variable named by nickServPasswordEnv and
+	// never stored or shown by /set.
+	NickServPassword string `json:"-"`
+
+	// NotifyInterval is how often to check who in the notify list is online
+	// on servers without MONITOR. Zero disables checking.
+	NotifyInterval Duration `json:"notify_interval"`
+	// NotifyPane shows the nicks in the notify list that are online next
+	// to the chat.
+	NotifyPane bool `json:"notify_pane"`
+
+	// SendTyping lets others see when you are typing a message, on servers
+	// that support it.
+	SendTyping bool `json:"send_typing"`
+
+	// TLSCert and TLSKey are the paths to a PEM encoded client certificate
+	// and its key, used to identify with CertFP or SASL EXTERNAL. The key may
+	// be in the certificate file.
+	TLSCert string `json:"tls_cert"`
+	TLSKey  string `json:"tls_key"`
+	// TLSCA is the path to a PEM encoded bundle of CAs trusted to sign the
+	// server's certificate, instead of the system's.
+	TLSCA string `json:"tls_ca"`
+	// TLSPins are the SHA-256 fingerprints of accepted server certificates.
+	TLSPins []string `json:"tls_pins"`
+	// SASLMechanism is "external" to log in with the client certificate.
+	SASLMechanism string `json:"sasl_mechanism"`
+
+	// RankStyles are the styles used to draw each nick prefix in the user list.
+	RankStyles RankStyles `json:"rank_styles"`
+}
+
+// RankStyles maps nick prefixes, such as "@", to a termui style, such as "fg:cyan".
+type RankStyles map[string]string
+
+// DefaultRankStyles are the initial RankStyles.
+func DefaultRankStyles() RankStyles {
+	return RankStyles{
+		"~": "fg:red",
+		"&": "fg:magenta",
+		"@": "fg:cyan",
+		"%": "fg:green",
+		"+": "fg:yellow",
+	}
+}
+
+// DefaultSettings returns the initial Settings.
+func DefaultSettings() Settings {
+	return Settings{
+		AutoAwayMessage: "Auto-away",
+		BanMask:         []string{"host"},
+		NotifyInterval:  Duration{time.Minute},
+		SendTyping:      true,
+		RankStyles:      DefaultRankStyles(),
+	}
+}
+
+const settingsStoreSection = "settings"
+
+// nickServPasswordEnv is the environment variable holding the NickServ password.
+const nickServPasswordEnv = "SQUIRSSI_NICKSERV_PASSWORD"
+
+// A SettingsManager keeps track of the current Settings.
+type SettingsManager struct {
+	current Settings
+	store   *Store
+
+	mu sync.RWMutex
+}
+
+func NewSettingsManager(store *Store) *SettingsManager {
+	s := DefaultSettings()
+	s.NickServPassword = os.Getenv(nickServPasswordEnv)
+	return &SettingsManager{current: s, store: store}
+}
+
+// Load restores the Settings from the Store.
+func (sm *SettingsManager) Load() error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	s := DefaultSettings()
+	if err := sm.store.Load(settingsStoreSection, &s); err != nil {
+		return err
+	}
+	s.NickServPassword = os.Getenv(nickServPasswordEnv)
+	sm.current = s
+	return nil
+}
+
+// Get returns a copy of the current Settings.
+func (sm *SettingsManager) Get() Settings {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return sm.current
+}
+
+func (sm *SettingsManager) values() (map[string]json.RawMessage, error) {
+	d, err := json.Marshal(sm.current)
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(d, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// A Setting is the name and encoded value of a single setting.
+type Setting struct {
+	Name  string
+	Value string
+}
+
+// All returns every setting, sorted by name.
+func (sm *SettingsManager) All() ([]Setting, error) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	vals, err := sm.values()
+	if err != nil {
+		return nil, err
+	}
+	var res []Setting
+	for k, v := range vals {
+		res = append(res, Setting{k, string(v)})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res, nil
+}
+
+// Set changes the named setting to value.
+// value may be JSON, a plain string, or a comma separated list.
+func (sm *SettingsManager) Set(name, value string) error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	vals, err := sm.values()
+	if err != nil {
+		return err
+	}
+	if _, ok := vals[name]; !ok {
+		return errors.Errorf("no setting named %s", name)
+	}
+	var candidates []json.RawMessage
+	if json.Valid([]byte(value)) {
+		candidates = append(candidates, json.RawMessage(value))
+	}
+	if v, err := json.Marshal(value); err == nil {
+		candidates = append(candidates, v)
+	}
+	if v, err := json.Marshal(strings.Fields(strings.ReplaceAll(value, ",", " "))); err == nil {
+		candidates = append(candidates, v)
+	}
+	for _, c := range candidates {
+		vals[name] = c
+		d, err := json.Marshal(vals)
+		if err != nil {
+			continue
+		}
+		// decode into a fresh value, slices in the current settings may be shared
+		var s Settings
+		if err := json.Unmarshal(d, &s); err != nil {
+			continue
+		}
+		s.NickServPassword = sm.current.NickServPassword
+		sm.current = s
+		return sm.store.Save(settingsStoreSection, sm.current)
+	}
+	return errors.Errorf("invalid value for %s: %s", name, value)
+}
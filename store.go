@@ -0,0 +1,96 @@
+package squirssi
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// A Store persists state between sessions.
+// Each part of squirssi that needs to remember something saves its own
+// named section in the Store. Sections that are not understood are kept
+// intact when the Store is written back to disk.
+type Store struct {
+	path     string
+	sections map[string]json.RawMessage
+
+	mu sync.Mutex
+}
+
+func NewStore() *Store {
+	return &Store{sections: make(map[string]json.RawMessage)}
+}
+
+// Open reads the Store from the given file, if it exists.
+// All subsequent saves are written to the same file.
+func (s *Store) Open(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.path = path
+	d, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return errors.Wrap(err, "store: failed to read file")
+	}
+	sections := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(d, &sections); err != nil {
+		return errors.Wrapf(err, "store: failed to decode %s", path)
+	}
+	s.sections = sections
+	return nil
+}
+
+// Load decodes the named section into v.
+// v is left untouched if the section does not exist.
+func (s *Store) Load(name string, v interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.sections[name]
+	if !ok {
+		return nil
+	}
+	if err := json.Unmarshal(d, v); err != nil {
+		return errors.Wrapf(err, "store: failed to decode section %s", name)
+	}
+	return nil
+}
+
+// Save encodes v as the named section and writes the Store to disk.
+func (s *Store) Save(name string, v interface{}) error {
+	d, err := json.Marshal(v)
+	if err != nil {
+		return errors.Wrapf(err, "store: failed to encode section %s", name)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sections[name] = d
+	return s.write()
+}
+
+func (s *Store) write() error {
+	if s.path == "" {
+		// not backed by a file, nothing to do
+		return nil
+	}
+	d, err := json.MarshalIndent(s.sections, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "store: failed to encode")
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return errors.Wrap(err, "store: failed to create directory")
+	}
+	// write to a temporary file first so a crash can't leave a truncated store
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, d, 0644); err != nil {
+		return errors.Wrap(err, "store: failed to write file")
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return errors.Wrap(err, "store: failed to replace file")
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package squirssi
+
+import (
+	"strings"
+)
+
+var tagValueUnescaper = strings.NewReplacer(
+	`\:`, ";",
+	`\s`, " ",
+	`\\`, `\`,
+	`\r`, "\r",
+	`\n`, "\n",
+)
+
+var tagValueEscaper = strings.NewReplacer(
+	";", `\:`,
+	" ", `\s`,
+	`\`, `\\`,
+	"\r", `\r`,
+	"\n", `\n`,
+)
+
+// parseTags returns the IRCv3 message tags at the start of a raw line.
+func parseTags(raw string) map[string]string {
+	if !strings.HasPrefix(raw, "@") {
+		return nil
+	}
+	end := strings.IndexByte(raw, ' ')
+	if end < 0 {
+		end = len(raw)
+	}
+	tags := make(map[string]string)
+	for _, t := range strings.Split(raw[1:end], ";") {
+		if t == "" {
+			continue
+		}
+		kv := strings.SplitN(t, "=", 2)
+		v := ""
+		if len(kv) > 1 {
+			v = tagValueUnescaper.Replace(kv[1])
+		}
+		tags[kv[0]] = v
+	}
+	return tags
+}
+
+// formatTags returns tags in the form sent at the start of a raw line,
+// including the leading @ and trailing space, or an empty string if there
+// are no tags.
+func formatTags(tags map[string]string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	var parts []string
+	for k, v := range tags {
+		if v == "" {
+			parts = append(parts, k)
+		} else {
+			parts = append(parts, k+"="+tagValueEscaper.Replace(v))
+		}
+	}
+	return "@" + strings.Join(parts, ";") + " "
+}
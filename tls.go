@@ -0,0 +1,249 @@
+package squirssi
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"code.dopame.me/veonik/squircy3/irc"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Fingerprint returns the SHA-256 fingerprint of a certificate as lowercase hex.
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// normalizeFingerprint removes separators from a fingerprint, such as the
+// colons in AB:CD:EF, and lowercases it.
+func normalizeFingerprint(fp string) string {
+	return strings.ToLower(strings.NewReplacer(":", "", " ", "", "-", "").Replace(fp))
+}
+
+// pinned returns true if the certificate matches one of pins.
+func pinned(cert *x509.Certificate, pins []string) bool {
+	fp := Fingerprint(cert)
+	for _, p := range pins {
+		if normalizeFingerprint(p) == fp {
+			return true
+		}
+	}
+	return false
+}
+
+// A pinError is returned when the server's certificate does not match any pin.
+type pinError struct {
+	fingerprint string
+}
+
+func (e pinError) Error() string {
+	return "certificate fingerprint " + e.fingerprint + " is not pinned"
+}
+
+// TLSConfig builds the TLS settings for connecting to a server. The server
+// name is taken from the address being dialed.
+// When pins are configured, the server's certificate must match one of them
+// and the usual chain verification is skipped, allowing self-signed certificates.
+func (s Settings) TLSConfig() (*tls.Config, error) {
+	cfg := &tls.Config{}
+	if s.TLSCert != "" {
+		key := s.TLSKey
+		if key == "" {
+			// the key may be in the same file as the certificate
+			key = s.TLSCert
+		}
+		cert, err := tls.LoadX509KeyPair(s.TLSCert, key)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to load client certificate")
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	if s.TLSCA != "" {
+		pem, err := ioutil.ReadFile(s.TLSCA)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to read CA bundle")
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf("no certificates found in %s", s.TLSCA)
+		}
+		cfg.RootCAs = pool
+	}
+	if len(s.TLSPins) > 0 {
+		pins := append([]string{}, s.TLSPins...)
+		cfg.InsecureSkipVerify = true
+		cfg.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return errors.New("server sent no certificate")
+			}
+			cert, err := x509.ParseCertificate(rawCerts[0])
+			if err != nil {
+				return err
+			}
+			if !pinned(cert, pins) {
+				return pinError{Fingerprint(cert)}
+			}
+			return nil
+		}
+	}
+	return cfg, nil
+}
+
+// customTLS returns true if connecting needs more than the default TLS
+// settings.
+func (s Settings) customTLS() bool {
+	return s.TLSCert != "" || s.TLSCA != "" || len(s.TLSPins) > 0
+}
+
+// saslExternal returns true if SASL EXTERNAL should be used to log in with
+// the client certificate.
+func (s Settings) saslExternal() bool {
+	return s.TLSCert != "" && strings.EqualFold(s.SASLMechanism, "external")
+}
+
+// tlsVersionNames are the names of TLS protocol versions.
+var tlsVersionNames = map[uint16]string{
+	tls.VersionTLS10: "TLS 1.0",
+	tls.VersionTLS11: "TLS 1.1",
+	tls.VersionTLS12: "TLS 1.2",
+	tls.VersionTLS13: "TLS 1.3",
+}
+
+// A TLSInfo describes a TLS connection to a server.
+type TLSInfo struct {
+	Server  string
+	Version string
+	Cipher  string
+	Chain   []*x509.Certificate
+	// Pinned is true if the server's certificate matches a configured pin.
+	Pinned bool
+}
+
+// A TLSManager keeps the TLS settings applied to the connection and the
+// details of the last handshake made with them.
+type TLSManager struct {
+	config *tls.Config
+	state  *tls.ConnectionState
+
+	mu sync.Mutex
+}
+
+func NewTLSManager() *TLSManager {
+	return &TLSManager{}
+}
+
+// Reset forgets the last handshake. The applied settings are kept, as
+// go-ircevent uses them again when it reconnects.
+func (tm *TLSManager) Reset() {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	tm.state = nil
+}
+
+// Applied returns true if cfg is the configuration set up by squirssi.
+func (tm *TLSManager) Applied(cfg *tls.Config) bool {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	return cfg != nil && cfg == tm.config
+}
+
+func (tm *TLSManager) apply(cfg *tls.Config) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	tm.config = cfg
+	tm.state = nil
+}
+
+func (tm *TLSManager) handshake(st tls.ConnectionState) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	tm.state = &st
+}
+
+// Info describes the last handshake made with the applied settings.
+func (tm *TLSManager) Info(pins []string) (TLSInfo, bool) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	if tm.state == nil {
+		return TLSInfo{}, false
+	}
+	info := TLSInfo{
+		Server:  tm.state.ServerName,
+		Version: tlsVersionNames[tm.state.Version],
+		Cipher:  tls.CipherSuiteName(tm.state.CipherSuite),
+		Chain:   tm.state.PeerCertificates,
+	}
+	if info.Version == "" {
+		info.Version = "unknown"
+	}
+	if len(info.Chain) > 0 {
+		info.Pinned = pinned(info.Chain[0], pins)
+	}
+	return info, true
+}
+
+// configureTLS puts the configured client certificate, CA bundle and pins
+// on conn before it is dialed, recording the details of each handshake made
+// with them for /tlsinfo. If the settings cannot be loaded, conn is set up
+// to fail its handshake rather than connect without them.
+func (srv *Server) configureTLS(conn *irc.Connection) {
+	if !conn.UseTLS {
+		return
+	}
+	cfg, err := srv.settings.Get().TLSConfig()
+	if err != nil {
+		logrus.Errorln("tls: refusing to connect,", err)
+		cfg = &tls.Config{
+			VerifyConnection: func(tls.ConnectionState) error {
+				return err
+			},
+		}
+		conn.TLSConfig = cfg
+		return
+	}
+	cfg.VerifyConnection = func(st tls.ConnectionState) error {
+		srv.tlsm.handshake(st)
+		return nil
+	}
+	conn.TLSConfig = cfg
+	srv.tlsm.apply(cfg)
+}
+
+// checkTLS returns false and disconnects if conn was dialed without the
+// configured TLS settings, which happens if they could not be applied
+// before dialing.
+func (srv *Server) checkTLS(conn *irc.Connection) bool {
+	if !conn.UseTLS || srv.tlsm.Applied(conn.TLSConfig) || !srv.settings.Get().customTLS() {
+		return true
+	}
+	logrus.Errorln("tls: disconnecting, the connection was not made with the configured TLS settings")
+	go srv.disconnect()
+	return false
+}
+
+// disconnect closes the connection to the server.
+func (srv *Server) disconnect() {
+	if err := srv.irc.Disconnect(); err != nil {
+		logrus.Warnln("tls: failed to disconnect:", err)
+	}
+}
+
+// configureSASL has go-ircevent log in with SASL EXTERNAL while
+// registering, using the client certificate, unless it is set up to log
+// in with a password already.
+func (srv *Server) configureSASL(conn *irc.Connection) {
+	if !conn.UseTLS || !srv.settings.Get().saslExternal() {
+		return
+	}
+	if conn.UseSASL && conn.SASLMech != "EXTERNAL" {
+		return
+	}
+	conn.UseSASL = true
+	conn.SASLMech = "EXTERNAL"
+}
@@ -0,0 +1,110 @@
+package squirssi
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// maxTopicHistory is the number of previous topics kept for each channel.
+const maxTopicHistory = 50
+
+// A TopicEntry is a topic that was set on a channel.
+type TopicEntry struct {
+	Topic string    `json:"topic"`
+	SetBy string    `json:"set_by"`
+	SetAt time.Time `json:"set_at"`
+}
+
+const topicsStoreSection = "topic_history"
+
+// TopicHistory keeps the previous topics of each channel on each network.
+type TopicHistory struct {
+	networks map[string]map[string][]TopicEntry
+	store    *Store
+	casemap  *CaseMapper
+
+	mu sync.Mutex
+}
+
+func NewTopicHistory(store *Store, casemap *CaseMapper) *TopicHistory {
+	return &TopicHistory{networks: make(map[string]map[string][]TopicEntry), store: store, casemap: casemap}
+}
+
+// Load restores the topic history from the Store.
+func (th *TopicHistory) Load() error {
+	th.mu.Lock()
+	defer th.mu.Unlock()
+	networks := make(map[string]map[string][]TopicEntry)
+	if err := th.store.Load(topicsStoreSection, &networks); err != nil {
+		return err
+	}
+	th.networks = networks
+	return nil
+}
+
+// Add records a topic for the given channel on network.
+// Nothing is recorded if the topic is the same as the most recent entry.
+func (th *TopicHistory) Add(network, channel string, e TopicEntry) {
+	th.mu.Lock()
+	defer th.mu.Unlock()
+	channels, ok := th.networks[network]
+	if !ok {
+		channels = make(map[string][]TopicEntry)
+		th.networks[network] = channels
+	}
+	k := th.casemap.Fold(channel)
+	l := channels[k]
+	if n := len(l); n > 0 && l[n-1].Topic == e.Topic {
+		if l[n-1].SetBy == "" {
+			// fill in details we didn't know before
+			l[n-1] = e
+		} else {
+			return
+		}
+	} else {
+		l = append(l, e)
+		if len(l) > maxTopicHistory {
+			l = l[len(l)-maxTopicHistory:]
+		}
+	}
+	channels[k] = l
+	if err := th.store.Save(topicsStoreSection, th.networks); err != nil {
+		logrus.Warnln("topic: failed to save history:", err)
+	}
+}
+
+// History returns the recorded topics for channel on network, oldest first.
+func (th *TopicHistory) History(network, channel string) []TopicEntry {
+	th.mu.Lock()
+	defer th.mu.Unlock()
+	return append([]TopicEntry{}, th.networks[network][th.casemap.Fold(channel)]...)
+}
+
+// setTopic updates the topic of ch and records it in the history.
+func (srv *Server) setTopic(ch *Channel, e TopicEntry) {
+	ch.mu.Lock()
+	ch.topic = e.Topic
+	ch.topicSetBy = e.SetBy
+	ch.topicSetAt = e.SetAt
+	ch.mu.Unlock()
+	if e.Topic != "" {
+		srv.topics.Add(srv.Network(), ch.Title(), e)
+	}
+}
+
+// topicCompletion returns the current topic of ch if input is a /topic
+// command with nothing typed after the channel.
+func topicCompletion(input string, ch *Channel, casemap *CaseMapper) (string, bool) {
+	args := strings.Split(input, " ")
+	if args[0] != "topic" || args[len(args)-1] != "" {
+		return "", false
+	}
+	if len(args) == 3 && !casemap.Equal(args[1], ch.Title()) || len(args) > 3 || len(args) < 2 {
+		return "", false
+	}
+	topic := ch.Topic()
+	return topic, topic != ""
+}
@@ -0,0 +1,310 @@
+package squirssi
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"code.dopame.me/veonik/squircy3/irc"
+
+	"code.dopame.me/veonik/squirssi/widget"
+)
+
+// Typing states sent in the IRCv3 +typing client tag.
+const (
+	typingActive = "active"
+	typingPaused = "paused"
+	typingDone   = "done"
+)
+
+const (
+	// typingThrottle is the least time between sending active notifications.
+	typingThrottle = 3 * time.Second
+	// typingPause is how long after the last keypress to send paused.
+	typingPause = 5 * time.Second
+	// typingActiveTimeout and typingPausedTimeout are how long others are
+	// shown as typing without hearing from them again.
+	typingActiveTimeout = 6 * time.Second
+	typingPausedTimeout = 30 * time.Second
+)
+
+// A typingUpdate is a typing notification to send.
+type typingUpdate struct {
+	target string
+	state  string
+}
+
+// typingUser is someone typing in a channel or query.
+type typingUser struct {
+	nick    string
+	state   string
+	expires time.Time
+}
+
+// A TypingManager tracks what the user is typing and who else is typing
+// in each window.
+type TypingManager struct {
+	// target is where the user is typing, and state what they last sent there.
+	target string
+	state  string
+	text   string
+	sent   time.Time
+	typed  time.Time
+
+	// typing maps folded targets to the users typing there, by folded nick.
+	typing  map[string]map[string]typingUser
+	casemap *CaseMapper
+
+	mu sync.Mutex
+}
+
+func NewTypingManager(casemap *CaseMapper) *TypingManager {
+	return &TypingManager{typing: make(map[string]map[string]typingUser), casemap: casemap}
+}
+
+// Reset forgets everyone typing and what the user was typing.
+func (tm *TypingManager) Reset() {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	tm.target = ""
+	tm.state = ""
+	tm.text = ""
+	tm.typing = make(map[string]map[string]typingUser)
+}
+
+// Input records the contents of the input box, typed to target, and
+// returns the notifications to send. target is empty when the user is not
+// typing a message.
+func (tm *TypingManager) Input(target, text string, now time.Time) []typingUpdate {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	var res []typingUpdate
+	if tm.state != "" && !tm.casemap.Equal(tm.target, target) {
+		// moved away from the window the user was typing in
+		res = append(res, typingUpdate{tm.target, typingDone})
+		tm.state = ""
+	}
+	tm.target = target
+	if target == "" || text == tm.text {
+		tm.text = text
+		return res
+	}
+	tm.text = text
+	if text == "" {
+		if tm.state != "" {
+			res = append(res, typingUpdate{target, typingDone})
+			tm.state = ""
+		}
+		return res
+	}
+	tm.typed = now
+	if tm.state != typingActive || now.Sub(tm.sent) >= typingThrottle {
+		tm.state = typingActive
+		tm.sent = now
+		res = append(res, typingUpdate{target, typingActive})
+	}
+	return res
+}
+
+// Idle returns the target to send paused to if the user stopped typing.
+func (tm *TypingManager) Idle(now time.Time) (string, bool) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	if tm.state != typingActive || now.Sub(tm.typed) < typingPause {
+		return "", false
+	}
+	tm.state = typingPaused
+	tm.sent = now
+	return tm.target, true
+}
+
+// Sent records that the user sent a message to target, which ends typing.
+func (tm *TypingManager) Sent(target string) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	if tm.casemap.Equal(tm.target, target) {
+		tm.state = ""
+	}
+}
+
+// Set records that nick is typing in target, or stopped if state is done.
+// It returns true if anything changed.
+func (tm *TypingManager) Set(target, nick, state string, now time.Time) bool {
+	if state == typingDone {
+		return tm.Stop(target, nick)
+	}
+	timeout := typingActiveTimeout
+	if state == typingPaused {
+		timeout = typingPausedTimeout
+	} else if state != typingActive {
+		return false
+	}
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	key := tm.casemap.Fold(target)
+	users, ok := tm.typing[key]
+	if !ok {
+		users = make(map[string]typingUser)
+		tm.typing[key] = users
+	}
+	prev := users[tm.casemap.Fold(nick)]
+	users[tm.casemap.Fold(nick)] = typingUser{nick: nick, state: state, expires: now.Add(timeout)}
+	return prev.state != state
+}
+
+// Stop records that nick is no longer typing in target, returning true if
+// they were.
+func (tm *TypingManager) Stop(target, nick string) bool {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	users, ok := tm.typing[tm.casemap.Fold(target)]
+	if !ok {
+		return false
+	}
+	if _, ok := users[tm.casemap.Fold(nick)]; !ok {
+		return false
+	}
+	delete(users, tm.casemap.Fold(nick))
+	return true
+}
+
+// Expire forgets everyone who has not been heard from in time, returning
+// true if anyone was forgotten.
+func (tm *TypingManager) Expire(now time.Time) bool {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	changed := false
+	for key, users := range tm.typing {
+		for n, u := range users {
+			if now.After(u.expires) {
+				delete(users, n)
+				changed = true
+			}
+		}
+		if len(users) == 0 {
+			delete(tm.typing, key)
+		}
+	}
+	return changed
+}
+
+// Typing returns the nicks actively typing in target, sorted.
+func (tm *TypingManager) Typing(target string) []string {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	var res []string
+	for _, u := range tm.typing[tm.casemap.Fold(target)] {
+		if u.state == typingActive {
+			res = append(res, u.nick)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// typingText describes who is typing, or returns an empty string.
+func typingText(nicks []string) string {
+	switch len(nicks) {
+	case 0:
+		return ""
+	case 1:
+		return nicks[0] + " is typing…"
+	case 2, 3:
+		return strings.Join(nicks[:len(nicks)-1], ", ") + " and " + nicks[len(nicks)-1] + " are typing…"
+	}
+	return fmt.Sprintf("%d people are typing…", len(nicks))
+}
+
+// clientTagAllowed returns true if the server lets clients send the given
+// client-only tag, without the + prefix.
+func (srv *Server) clientTagAllowed(name string) bool {
+	if !srv.caps.Enabled("message-tags") {
+		return false
+	}
+	v, _ := srv.isupport.Value("CLIENTTAGDENY")
+	denied := false
+	for _, t := range strings.Split(v, ",") {
+		switch t {
+		case "*":
+			denied = true
+		case name:
+			return false
+		case "-" + name:
+			return true
+		}
+	}
+	return !denied
+}
+
+// sendTyping tells target that the user is typing, if allowed.
+func (srv *Server) sendTyping(target, state string) {
+	if !srv.settings.Get().SendTyping || !srv.clientTagAllowed("typing") {
+		return
+	}
+	srv.IRCDoAsync(func(conn *irc.Connection) error {
+		conn.SendRawf("@+typing=%s TAGMSG %s", state, target)
+		return nil
+	})
+}
+
+// updateTyping sends typing notifications for the contents of the input box.
+func (srv *Server) updateTyping() {
+	target, text := "", ""
+	if srv.inputTextBox.Mode() == widget.ModeMessage {
+		switch win := srv.windows.Active().(type) {
+		case *Channel, *DirectMessage:
+			target = win.Title()
+			text = srv.inputTextBox.Peek()
+		}
+	}
+	for _, u := range srv.typing.Input(target, text, time.Now()) {
+		srv.sendTyping(u.target, u.state)
+	}
+}
+
+// stopTyping records that nick is no longer typing in target.
+func (srv *Server) stopTyping(target, nick string) {
+	if srv.typing.Stop(target, nick) {
+		srv.events.Emit("ui.DIRTY", nil)
+	}
+}
+
+// startTypingTimer sends paused once the user stops typing and forgets
+// others who stopped typing.
+func (srv *Server) startTypingTimer() {
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-srv.done:
+			return
+		case now := <-t.C:
+			if target, ok := srv.typing.Idle(now); ok {
+				srv.sendTyping(target, typingPaused)
+			}
+			if srv.typing.Expire(now) {
+				srv.events.Emit("ui.DIRTY", nil)
+			}
+		}
+	}
+}
+
+func onIRCTagmsg(srv *Server, ev *IRCEvent) {
+	target := ev.Target
+	if srv.isMe(target) {
+		target = ev.Nick
+	}
+	if _, ok := ev.Tags["+draft/react"]; ok {
+		onIRCReaction(srv, ev, target)
+	}
+	if srv.isMe(ev.Nick) {
+		return
+	}
+	if state, ok := ev.Tags["+typing"]; ok {
+		if srv.typing.Set(target, ev.Nick, state, time.Now()) {
+			srv.events.Emit("ui.DIRTY", nil)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package squirssi
+
+import (
+	"strings"
+)
+
+// userWindows returns the windows shared with nick: channels they are in
+// and a direct message window with them.
+func (srv *Server) userWindows(nick string) []Window {
+	var res []Window
+	for _, win := range srv.windows.Windows() {
+		switch w := win.(type) {
+		case *Channel:
+			if w.HasUser(nick) {
+				res = append(res, w)
+			}
+		case *DirectMessage:
+			if srv.casemap.Equal(w.Title(), nick) {
+				res = append(res, w)
+			}
+		}
+	}
+	return res
+}
+
+// markAway shows each nick in users as away or back in the user list of
+// every channel.
+func (srv *Server) markAway(users map[string]bool) {
+	changed := false
+	for _, win := range srv.windows.Windows() {
+		ch, ok := win.(*Channel)
+		if !ok {
+			continue
+		}
+		for nick, away := range users {
+			if ch.SetUserAway(nick, away) {
+				changed = true
+			}
+		}
+	}
+	if changed {
+		srv.events.Emit("ui.DIRTY", nil)
+	}
+}
+
+func onIRCAway(srv *Server, ev *IRCEvent) {
+	// away-notify sends AWAY without a message when the user is back
+	away := len(ev.Args) > 0 && ev.Args[0] != ""
+	message := ""
+	if away {
+		message = ev.Args[0]
+	}
+	srv.users.Update(ev.Nick, func(u *UserInfo) {
+		u.Away = away
+		u.AwayMessage = message
+	})
+	srv.markAway(map[string]bool{ev.Nick: away})
+}
+
+func onIRCAccount(srv *Server, ev *IRCEvent) {
+	if len(ev.Args) == 0 {
+		return
+	}
+	account := ev.Args[0]
+	if account == "*" {
+		account = ""
+	}
+	srv.users.Update(ev.Nick, func(u *UserInfo) {
+		u.Account = account
+	})
+	for _, win := range srv.userWindows(ev.Nick) {
+		WriteAccount(win, SomeNick(ev.Nick), account)
+	}
+}
+
+func onIRCChghost(srv *Server, ev *IRCEvent) {
+	if len(ev.Args) < 2 {
+		return
+	}
+	srv.users.Seen(ev.Nick, ev.Args[0], ev.Args[1])
+	for _, win := range srv.userWindows(ev.Nick) {
+		WriteChghost(win, SomeNick(ev.Nick), ev.Args[0]+"@"+ev.Args[1])
+	}
+}
+
+func onIRCSetname(srv *Server, ev *IRCEvent) {
+	if len(ev.Args) == 0 {
+		return
+	}
+	srv.users.Update(ev.Nick, func(u *UserInfo) {
+		u.RealName = ev.Args[0]
+	})
+	for _, win := range srv.userWindows(ev.Nick) {
+		WriteSetname(win, SomeNick(ev.Nick), ev.Args[0])
+	}
+}
+
+func onIRCInvite(srv *Server, ev *IRCEvent) {
+	if len(ev.Args) < 2 {
+		return
+	}
+	target := ev.Args[0]
+	channel := ev.Args[1]
+	if srv.isMe(target) {
+		WriteInvite(srv.windows.Index(0), SomeNick(ev.Nick), MyNick(target), channel)
+		return
+	}
+	// invite-notify tells channel members about invites from others
+	win := srv.windows.Named(channel)
+	if win == nil {
+		return
+	}
+	WriteInvite(win, SomeNick(ev.Nick), SomeNick(target), channel)
+}
+
+// parseNamesEntry splits a nick from a NAMES reply sent with
+// userhost-in-names into the prefixed nick and the user and host.
+func parseNamesEntry(entry string) (nick, user, host string) {
+	i := strings.IndexByte(entry, '!')
+	if i < 0 {
+		return entry, "", ""
+	}
+	nick = entry[:i]
+	uh := entry[i+1:]
+	if j := strings.IndexByte(uh, '@'); j >= 0 {
+		user, host = uh[:j], uh[j+1:]
+	}
+	return nick, user, host
+}
@@ -0,0 +1,112 @@
+package squirssi
+
+import (
+	"sync"
+)
+
+// UserInfo contains what is known about a user on the network.
+type UserInfo struct {
+	Nick     string
+	User     string
+	Host     string
+	RealName string
+	// Account is the services account the user is logged in as, if any.
+	Account     string
+	Away        bool
+	AwayMessage string
+}
+
+// Hostmask returns the nick!user@host of the user.
+func (u UserInfo) Hostmask() string {
+	return u.Nick + "!" + u.User + "@" + u.Host
+}
+
+// A UserRegistry keeps track of the users seen on the current connection.
+type UserRegistry struct {
+	users   map[string]*UserInfo
+	casemap *CaseMapper
+
+	mu sync.RWMutex
+}
+
+func NewUserRegistry(casemap *CaseMapper) *UserRegistry {
+	return &UserRegistry{users: make(map[string]*UserInfo), casemap: casemap}
+}
+
+func (r *UserRegistry) key(nick string) string {
+	return r.casemap.Fold(nick)
+}
+
+// Reset forgets all known users.
+func (r *UserRegistry) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.users = make(map[string]*UserInfo)
+}
+
+// Get returns the known information about the given nick.
+func (r *UserRegistry) Get(nick string) (UserInfo, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if u, ok := r.users[r.key(nick)]; ok {
+		return *u, true
+	}
+	return UserInfo{}, false
+}
+
+// Update calls fn with the UserInfo for nick, adding the user if necessary.
+func (r *UserRegistry) Update(nick string, fn func(u *UserInfo)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	k := r.key(nick)
+	u, ok := r.users[k]
+	if !ok {
+		u = &UserInfo{Nick: nick}
+		r.users[k] = u
+	}
+	fn(u)
+}
+
+// Seen records the user and host of nick from a message prefix.
+func (r *UserRegistry) Seen(nick, user, host string) {
+	if nick == "" || user == "" || host == "" {
+		// not a full prefix, probably a server
+		return
+	}
+	r.Update(nick, func(u *UserInfo) {
+		u.Nick = nick
+		u.User = user
+		u.Host = host
+	})
+}
+
+// Rename moves the information for nick to newNick.
+func (r *UserRegistry) Rename(nick, newNick string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	u, ok := r.users[r.key(nick)]
+	if !ok {
+		return
+	}
+	delete(r.users, r.key(nick))
+	u.Nick = newNick
+	r.users[r.key(newNick)] = u
+}
+
+// Remove forgets the given nick.
+func (r *UserRegistry) Remove(nick string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.users, r.key(nick))
+}
+
+// forgetUnlessShared removes nick from the UserRegistry if they are no
+// longer in any open channel.
+func (srv *Server) forgetUnlessShared(nick string) {
+	for _, win := range srv.windows.Windows() {
+		if ch, ok := win.(*Channel); ok && ch.HasUser(nick) {
+			return
+		}
+	}
+	srv.users.Remove(nick)
+}
@@ -0,0 +1,164 @@
+package squirssi
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A WhoResult is a single reply to a WHO query.
+type WhoResult struct {
+	UserInfo
+	Channel string
+	Flags   string
+}
+
+// A WhoQuery is a WHO request waiting for replies.
+type WhoQuery struct {
+	Mask string
+	// Token identifies replies to the query when it is sent with WHOX.
+	Token string
+	// Window where results are printed. Queries without a Window only
+	// populate the UserRegistry.
+	Window  Window
+	Results []WhoResult
+	// away is whether each user in the replies is away, applied to the
+	// channel user lists once the query is done.
+	away map[string]bool
+}
+
+// WhoManager tracks pending WHO queries.
+// Replies are matched to queries by their WHOX token, or otherwise by the
+// channel they are for. The end of each query names its mask.
+type WhoManager struct {
+	pending []*WhoQuery
+	// tokens is the number of WHOX tokens handed out.
+	tokens  int
+	casemap *CaseMapper
+
+	mu sync.Mutex
+}
+
+func NewWhoManager(casemap *CaseMapper) *WhoManager {
+	return &WhoManager{casemap: casemap}
+}
+
+// Reset forgets all pending queries.
+func (wm *WhoManager) Reset() {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+	wm.pending = nil
+}
+
+// push adds a pending query, giving it a token if it is sent with WHOX.
+func (wm *WhoManager) push(q *WhoQuery, whox bool) {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+	if whox {
+		// tokens are at most 3 digits
+		wm.tokens = wm.tokens%999 + 1
+		q.Token = strconv.Itoa(wm.tokens)
+	}
+	wm.pending = append(wm.pending, q)
+}
+
+// query returns the pending query a reply with the given token and channel
+// belongs to. Replies without a token go to the query for their channel,
+// or else the oldest one sent without WHOX.
+// wm.mu must be held.
+func (wm *WhoManager) query(token, channel string) *WhoQuery {
+	if token != "" {
+		for _, q := range wm.pending {
+			if q.Token == token {
+				return q
+			}
+		}
+		return nil
+	}
+	var oldest *WhoQuery
+	for _, q := range wm.pending {
+		if q.Token != "" {
+			continue
+		}
+		if wm.casemap.Equal(q.Mask, channel) {
+			return q
+		}
+		if oldest == nil {
+			oldest = q
+		}
+	}
+	return oldest
+}
+
+// Expecting returns true if token belongs to a pending query.
+func (wm *WhoManager) Expecting(token string) bool {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+	return token != "" && wm.query(token, "") != nil
+}
+
+// Add adds a reply with the given WHOX token, if any, to the query it
+// belongs to. It returns false if there is no such query.
+func (wm *WhoManager) Add(token string, r WhoResult) bool {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+	q := wm.query(token, r.Channel)
+	if q == nil {
+		return false
+	}
+	if q.away == nil {
+		q.away = make(map[string]bool)
+	}
+	q.away[r.Nick] = r.Away
+	if q.Window != nil {
+		q.Results = append(q.Results, r)
+	}
+	return true
+}
+
+// Done removes and returns the oldest pending query for mask.
+func (wm *WhoManager) Done(mask string) *WhoQuery {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+	for i, q := range wm.pending {
+		if wm.casemap.Equal(q.Mask, mask) {
+			wm.pending = append(wm.pending[:i:i], wm.pending[i+1:]...)
+			return q
+		}
+	}
+	return nil
+}
+
+// who sends a WHO query for mask. Results are printed to win, if it is not nil.
+func (srv *Server) who(mask string, win Window) {
+	whox := srv.isupport.Has("WHOX")
+	q := &WhoQuery{Mask: mask, Window: win}
+	srv.whos.push(q, whox)
+	if whox {
+		srv.sendLabeled(win, "WHO "+mask+" %tcuhnfar,"+q.Token)
+	} else {
+		srv.sendLabeled(win, "WHO "+mask)
+	}
+}
+
+// whoReply records a reply to a WHO query, with its WHOX token if any.
+func (srv *Server) whoReply(token string, r WhoResult) {
+	r.Away = strings.Contains(r.Flags, "G")
+	srv.users.Update(r.Nick, func(u *UserInfo) {
+		u.Nick = r.Nick
+		u.User = r.User
+		u.Host = r.Host
+		u.RealName = r.RealName
+		if r.Account != "" {
+			u.Account = r.Account
+		}
+		if !r.Away {
+			u.AwayMessage = ""
+		}
+		u.Away = r.Away
+	})
+	if !srv.whos.Add(token, r) {
+		// not a reply to one of our queries, there is no end to wait for
+		srv.markAway(map[string]bool{r.Nick: r.Away})
+	}
+}
@@ -0,0 +1,233 @@
+package squirssi
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A WhoisResult is the combined reply to a WHOIS query.
+type WhoisResult struct {
+	UserInfo
+	// Server is the server the user is connected to, and ServerInfo its description.
+	Server     string
+	ServerInfo string
+	// Operator is the server's description of the user's operator status, if any.
+	Operator string
+	// Channels the user is in, with their prefixes, such as "@#squirssi".
+	Channels []string
+	Idle     time.Duration
+	SignOn   time.Time
+	// Secure is true if the user is connected using TLS.
+	Secure bool
+	// Received is when the reply was completed.
+	Received time.Time
+}
+
+// A WhoisQuery is a WHOIS request waiting for replies.
+type WhoisQuery struct {
+	// Window where the result is printed. Replies to queries that were not
+	// sent by squirssi are printed in the active window.
+	Window Window
+	Result WhoisResult
+}
+
+// WhoisManager collects WHOIS replies and caches the results.
+type WhoisManager struct {
+	pending map[string]*WhoisQuery
+	cache   map[string]WhoisResult
+	casemap *CaseMapper
+
+	mu sync.Mutex
+}
+
+func NewWhoisManager(casemap *CaseMapper) *WhoisManager {
+	return &WhoisManager{
+		pending: make(map[string]*WhoisQuery),
+		cache:   make(map[string]WhoisResult),
+		casemap: casemap,
+	}
+}
+
+// Reset forgets all pending queries and cached results.
+func (wm *WhoisManager) Reset() {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+	wm.pending = make(map[string]*WhoisQuery)
+	wm.cache = make(map[string]WhoisResult)
+}
+
+// Begin starts a query for nick, printing the result to win.
+func (wm *WhoisManager) Begin(nick string, win Window) {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+	wm.pending[wm.casemap.Fold(nick)] = &WhoisQuery{Window: win, Result: WhoisResult{UserInfo: UserInfo{Nick: nick}}}
+}
+
+// Pending returns true if replies for nick are being collected.
+func (wm *WhoisManager) Pending(nick string) bool {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+	_, ok := wm.pending[wm.casemap.Fold(nick)]
+	return ok
+}
+
+// Update calls fn with the result being collected for nick, starting a
+// query if there is none.
+func (wm *WhoisManager) Update(nick string, fn func(r *WhoisResult)) {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+	k := wm.casemap.Fold(nick)
+	q, ok := wm.pending[k]
+	if !ok {
+		q = &WhoisQuery{Result: WhoisResult{UserInfo: UserInfo{Nick: nick}}}
+		wm.pending[k] = q
+	}
+	fn(&q.Result)
+}
+
+// Done removes the query for nick and caches its result.
+func (wm *WhoisManager) Done(nick string) *WhoisQuery {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+	k := wm.casemap.Fold(nick)
+	q, ok := wm.pending[k]
+	if !ok {
+		return nil
+	}
+	delete(wm.pending, k)
+	q.Result.Received = time.Now()
+	if q.Result.User != "" {
+		// only cache users that exist
+		wm.cache[k] = q.Result
+	}
+	return q
+}
+
+// Cached returns the last WHOIS result for nick.
+func (wm *WhoisManager) Cached(nick string) (WhoisResult, bool) {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+	r, ok := wm.cache[wm.casemap.Fold(nick)]
+	return r, ok
+}
+
+// Rename moves the cached result for nick to newNick.
+func (wm *WhoisManager) Rename(nick, newNick string) {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+	k := wm.casemap.Fold(nick)
+	r, ok := wm.cache[k]
+	if !ok {
+		return
+	}
+	delete(wm.cache, k)
+	r.Nick = newNick
+	wm.cache[wm.casemap.Fold(newNick)] = r
+}
+
+// whois sends a WHOIS query for nick. The result is printed to win.
+func (srv *Server) whois(nick string, win Window) {
+	srv.whoises.Begin(nick, win)
+	// asking the user's server includes idle time
+	srv.sendLabeled(win, "WHOIS "+nick+" "+nick)
+}
+
+func onIRCWhois(srv *Server, ev *IRCEvent) {
+	if len(ev.Args) < 3 {
+		return
+	}
+	nick := ev.Args[1]
+	args := ev.Args[2:]
+	srv.whoises.Update(nick, func(r *WhoisResult) {
+		switch ev.Code {
+		case "311":
+			// RPL_WHOISUSER <nick> <user> <host> * :<realname>
+			if len(args) < 4 {
+				return
+			}
+			r.Nick = nick
+			r.User = args[0]
+			r.Host = args[1]
+			r.RealName = args[3]
+		case "312":
+			// RPL_WHOISSERVER <nick> <server> :<info>
+			r.Server = args[0]
+			if len(args) > 1 {
+				r.ServerInfo = args[1]
+			}
+		case "313":
+			// RPL_WHOISOPERATOR <nick> :is an IRC operator
+			r.Operator = args[len(args)-1]
+		case "317":
+			// RPL_WHOISIDLE <nick> <idle> [<signon>] :seconds idle
+			if idle, err := strconv.Atoi(args[0]); err == nil {
+				r.Idle = time.Duration(idle) * time.Second
+			}
+			if len(args) > 2 {
+				if signon, err := strconv.ParseInt(args[1], 10, 64); err == nil {
+					r.SignOn = time.Unix(signon, 0)
+				}
+			}
+		case "319":
+			// RPL_WHOISCHANNELS <nick> :{[prefix]<channel> }
+			r.Channels = append(r.Channels, strings.Fields(args[len(args)-1])...)
+		case "330":
+			// RPL_WHOISACCOUNT <nick> <account> :is logged in as
+			r.Account = args[0]
+		case "671":
+			// RPL_WHOISSECURE <nick> :is using a secure connection
+			r.Secure = true
+		case "301":
+			// RPL_AWAY <nick> :<message>
+			r.Away = true
+			r.AwayMessage = args[0]
+		}
+	})
+}
+
+func onIRC318(srv *Server, ev *IRCEvent) {
+	// RPL_ENDOFWHOIS
+	if len(ev.Args) < 2 {
+		return
+	}
+	q := srv.whoises.Done(ev.Args[1])
+	if q == nil || q.Result.User == "" {
+		// nothing was found, the server already sent an error
+		return
+	}
+	r := q.Result
+	srv.users.Update(r.Nick, func(u *UserInfo) {
+		u.Nick = r.Nick
+		u.User = r.User
+		u.Host = r.Host
+		u.RealName = r.RealName
+		u.Account = r.Account
+		u.Away = r.Away
+		u.AwayMessage = r.AwayMessage
+	})
+	win := q.Window
+	if win == nil {
+		win = srv.windows.NamedOrActive(r.Nick)
+	}
+	WriteWhois(win, r, srv.modeTypes().Prefixes, srv.settings.Get().RankStyles)
+}
+
+func onIRC369(srv *Server, ev *IRCEvent) {
+	// RPL_ENDOFWHOWAS
+	if len(ev.Args) < 2 {
+		return
+	}
+	// WHOWAS replies include RPL_WHOISSERVER, drop what was collected
+	srv.whoises.Done(ev.Args[1])
+}
+
+func onIRCWhowas(srv *Server, ev *IRCEvent) {
+	// RPL_WHOWASUSER <nick> <user> <host> * :<realname>
+	if len(ev.Args) < 6 {
+		return
+	}
+	win := srv.windows.NamedOrActive(ev.Args[1])
+	WriteWhowas(win, ev.Args[1], ev.Args[2]+"@"+ev.Args[3], ev.Args[5])
+}
@@ -21,6 +21,9 @@ type ChatPane struct {
 	TextStyle   ui.Style
 	SelectedRow int
 	LeftPadding int
+	// HighlightSelected draws the selected row in reverse, such as when
+	// scrolled up to pick a message.
+	HighlightSelected bool
 
 	ModeText  string
 	ModeStyle ui.Style
@@ -128,6 +131,15 @@ func (cp *ChatPane) Draw(buf *ui.Buffer) {
 	if cp.SelectedRow > 0 && len(rows) > cp.SelectedRow {
 		actualSelected = rows[cp.SelectedRow]
 	}
+	// wrapped rows making up the selected row, to highlight
+	selectedStart, selectedEnd := -1, -1
+	if cp.HighlightSelected && cp.SelectedRow >= 0 && len(rows) > cp.SelectedRow {
+		selectedEnd = rows[cp.SelectedRow]
+		selectedStart = 0
+		if cp.SelectedRow > 0 {
+			selectedStart = rows[cp.SelectedRow-1] + 1
+		}
+	}
 	topRow := 0
 
 	// adjust starting row based on the bounding box and the actual selected row
@@ -142,6 +154,9 @@ func (cp *ChatPane) Draw(buf *ui.Buffer) {
 		cells := actuals[row]
 		for j := 0; j < len(cells) && point.Y < cp.Inner.Max.Y; j++ {
 			style := cells[j].Style
+			if row >= selectedStart && row <= selectedEnd {
+				style.Modifier |= ui.ModifierReverse
+			}
 			if cells[j].Rune == '\n' {
 				point = image.Pt(cp.Inner.Min.X, point.Y+1)
 			} else {
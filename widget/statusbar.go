@@ -2,6 +2,7 @@ package widget
 
 import (
 	"image"
+	"unicode/utf8"
 
 	ui "github.com/gizak/termui/v3"
 	"github.com/gizak/termui/v3/widgets"
@@ -23,6 +24,10 @@ type StatusBarPane struct {
 	TabsWithActivity map[int]ActivityType
 	NoticeStyle      ui.Style
 	ActivityStyle    ui.Style
+
+	// StatusText is drawn right-aligned after the tabs, if set.
+	StatusText  string
+	StatusStyle ui.Style
 }
 
 func NewStatusBarPane() *StatusBarPane {
@@ -65,4 +70,11 @@ func (sb *StatusBarPane) Draw(buf *ui.Buffer) {
 
 		xCoordinate += 2
 	}
+
+	if sb.StatusText != "" {
+		x := sb.Inner.Max.X - utf8.RuneCountInString(sb.StatusText) - 1
+		if x > xCoordinate {
+			buf.SetString(sb.StatusText, sb.StatusStyle, image.Pt(x, sb.Inner.Min.Y))
+		}
+	}
 }
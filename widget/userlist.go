@@ -8,11 +8,20 @@ import (
 	"code.dopame.me/veonik/squirssi/colors"
 )
 
+// A RowSource provides rows to a UserList as they are needed.
+type RowSource interface {
+	// Len returns the total number of rows.
+	Len() int
+	// Rows returns the rows from start up to, but not including, end.
+	Rows(start, end int) []string
+}
+
 // A UserList contains a list of users on a channel.
 // This widget is based on the termui Table widget.
+// Only the rows that fit on screen are requested from the Source.
 type UserList struct {
 	ui.Block
-	Rows        []string
+	Source      RowSource
 	TextStyle   ui.Style
 	SelectedRow int
 }
@@ -31,10 +40,15 @@ func (ul *UserList) Draw(buf *ui.Buffer) {
 
 	yCoordinate := ul.Inner.Min.Y
 
+	total := 0
+	if ul.Source != nil {
+		total = ul.Source.Len()
+	}
+	if ul.SelectedRow >= total {
+		ul.SelectedRow = total - 1
+	}
 	if ul.SelectedRow < 0 {
 		ul.SelectedRow = 0
-	} else if ul.SelectedRow >= len(ul.Rows) {
-		ul.SelectedRow = len(ul.Rows) - 1
 	}
 
 	topRow := 0
@@ -50,9 +64,16 @@ func (ul *UserList) Draw(buf *ui.Buffer) {
 		topRow = 0
 	}
 
+	var rows []string
+	if ul.Source != nil {
+		rows = ul.Source.Rows(topRow, topRow+ul.Inner.Dy())
+	}
+
 	// draw rows
-	for i := topRow; i < len(ul.Rows) && yCoordinate < ul.Inner.Max.Y; i++ {
-		row := ul.Rows[i]
+	for _, row := range rows {
+		if yCoordinate >= ul.Inner.Max.Y {
+			break
+		}
 		colXCoordinate := ul.Inner.Min.X
 
 		rowStyle := ul.TextStyle
@@ -90,7 +111,7 @@ func (ul *UserList) Draw(buf *ui.Buffer) {
 	}
 
 	// draw DOWN_ARROW if needed
-	if len(ul.Rows) > topRow+ul.Inner.Dy() {
+	if total > topRow+ul.Inner.Dy() {
 		buf.SetCell(
 			ui.NewCell(ui.DOWN_ARROW, ui.NewStyle(colors.Grey42)),
 			image.Pt(ul.Inner.Min.X+1, ul.Inner.Max.Y-1),
@@ -4,13 +4,14 @@ import (
 	"bytes"
 	"fmt"
 	"io"
-	"regexp"
 	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	"code.dopame.me/veonik/squircy3/event"
+
+	"code.dopame.me/veonik/squirssi/widget"
 )
 
 type Window interface {
@@ -39,14 +40,19 @@ type Window interface {
 	// HasNotice returns true if the Window has new lines considered important since last touch.
 	HasNotice() bool
 
+	// Replace replaces the content of the most recent line written with the
+	// given token with line, keeping its timestamp. It returns false if there
+	// is no such line.
+	Replace(token, line string) bool
+
 	// padding returns how many characters wide the left gutter of the window is.
 	padding() int
 }
 
 type WindowWithUserList interface {
 	Window
-	// UserList returns the styled list of users.
-	UserList() []string
+	// UserList returns the list of users, styled and sorted by rank.
+	UserList(styles RankStyles) widget.RowSource
 	// Users returns just the usernames in the window.
 	Users() []string
 	// HasUser returns true if the window contains the given user.
@@ -57,15 +63,53 @@ type WindowWithUserList interface {
 	DeleteUser(name string) bool
 }
 
+// MessageInfo describes a message shown in a window, identified by its
+// IRCv3 msgid.
+type MessageInfo struct {
+	ID     string
+	Nick   string
+	Text   string
+	Action bool
+}
+
+// A Reaction is a reaction to a message and who reacted with it.
+type Reaction struct {
+	Text  string
+	Nicks []string
+}
+
+// lineMeta describes a line in a bufferedWindow.
+type lineMeta struct {
+	at time.Time
+	// msgid is the ID of the message the line is part of, if any.
+	msgid string
+	// reactions is true if the line shows the reactions to msgid.
+	reactions bool
+	// token identifies the write the line is part of, if set by the writer.
+	token string
+	// prefix is the length of the timestamp or padding before the content.
+	prefix int
+}
+
 type bufferedWindow struct {
 	name    string
 	lines   []string
 	current int
+	// meta holds what is known about each line, in order.
+	meta []lineMeta
+
+	// messages and reactions are the messages shown in the window and the
+	// reactions to them, by ID.
+	messages  map[string]MessageInfo
+	reactions map[string][]Reaction
 
 	hasUnseen  bool
 	hasNotice  bool
 	autoScroll bool
 
+	// log receives a copy of every line written to the window, if set.
+	log io.WriteCloser
+
 	events *event.Dispatcher
 	mu     sync.RWMutex
 }
@@ -89,30 +133,252 @@ func (c *bufferedWindow) Title() string {
 }
 
 func (c *bufferedWindow) Write(p []byte) (n int, err error) {
+	c.WriteMessage(MessageInfo{}, "", time.Now(), false, p)
+	return len(p), nil
+}
+
+// WriteMessage writes p as the lines of the message described by info,
+// sent at the given time. The lines are marked with token, if set, so they
+// can be found again with Replace and Identify. If insert is true, the lines
+// are placed after any lines written before then instead of at the end and
+// are not logged.
+func (c *bufferedWindow) WriteMessage(info MessageInfo, token string, at time.Time, insert bool, p []byte) {
 	c.mu.Lock()
 	defer c.events.Emit("ui.DIRTY", map[string]interface{}{
 		"name": c.name,
 	})
 	defer c.mu.Unlock()
-	lines := bytes.Split(p, []byte("\n"))
-	t := time.Now().Format("[15:04](fg:gray)  ")
+	t := at.Local().Format("[15:04](fg:gray)  ")
 	const padding = "       "
-	firstWritten := false
-	for _, l := range lines {
+	var lines []string
+	var meta []lineMeta
+	for _, l := range bytes.Split(p, []byte("\n")) {
 		if len(l) == 0 {
 			continue
 		}
-		if !firstWritten {
-			c.lines = append(c.lines, strings.TrimRight(t+string(l), "\n"))
-			firstWritten = true
-		} else {
-			c.lines = append(c.lines, strings.TrimRight(padding+string(l), "\n"))
+		prefix := padding
+		if len(lines) == 0 {
+			prefix = t
+		}
+		lines = append(lines, strings.TrimRight(prefix+string(l), "\n"))
+		meta = append(meta, lineMeta{at: at, msgid: info.ID, token: token, prefix: len(prefix)})
+		if !insert && c.log != nil {
+			if err := writeLogLine(c.log, string(l)); err != nil {
+				c.log.Close()
+				c.log = nil
+			}
 		}
 	}
+	if info.ID != "" {
+		if c.messages == nil {
+			c.messages = make(map[string]MessageInfo)
+		}
+		c.messages[info.ID] = info
+	}
+	i := len(c.lines)
+	if insert {
+		i = sort.Search(len(c.meta), func(i int) bool {
+			return c.meta[i].at.After(at)
+		})
+	}
+	c.insertLines(i, lines, meta)
 	c.hasUnseen = true
+}
+
+// insertLines puts lines before the line at index i.
+// c.mu must be held.
+func (c *bufferedWindow) insertLines(i int, lines []string, meta []lineMeta) {
+	if i == len(c.lines) {
+		c.lines = append(c.lines, lines...)
+		c.meta = append(c.meta, meta...)
+		return
+	}
+	c.lines = append(c.lines[:i:i], append(lines, c.lines[i:]...)...)
+	c.meta = append(c.meta[:i:i], append(meta, c.meta[i:]...)...)
+	if !c.autoScroll && c.current >= i {
+		// keep the same lines in view
+		c.current += len(lines)
+	}
+}
+
+// Message returns the message with the given ID, if it is in the window.
+func (c *bufferedWindow) Message(id string) (MessageInfo, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	m, ok := c.messages[id]
+	return m, ok
+}
+
+// MessageAt returns the message shown at the given line, or the closest one
+// before it.
+func (c *bufferedWindow) MessageAt(line int) (MessageInfo, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if line < 0 || line >= len(c.meta) {
+		line = len(c.meta) - 1
+	}
+	for i := line; i >= 0; i-- {
+		if id := c.meta[i].msgid; id != "" {
+			m, ok := c.messages[id]
+			return m, ok
+		}
+	}
+	return MessageInfo{}, false
+}
+
+// Identify records the ID of the message written with the given token.
+func (c *bufferedWindow) Identify(token string, info MessageInfo) bool {
+	if token == "" {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	found := false
+	for i := range c.meta {
+		if c.meta[i].token == token {
+			c.meta[i].msgid = info.ID
+			found = true
+		}
+	}
+	if !found {
+		return false
+	}
+	if c.messages == nil {
+		c.messages = make(map[string]MessageInfo)
+	}
+	c.messages[info.ID] = info
+	return true
+}
+
+// React adds nick's reaction to the message with the given ID, showing all
+// of its reactions on a line after it as rendered by render. It returns
+// false if the message is not in the window.
+func (c *bufferedWindow) React(id, nick, reaction string, render func(rs []Reaction) string) bool {
+	c.mu.Lock()
+	last := -1
+	for i := len(c.meta) - 1; i >= 0; i-- {
+		if c.meta[i].msgid == id {
+			last = i
+			break
+		}
+	}
+	if last < 0 {
+		c.mu.Unlock()
+		return false
+	}
+	if c.reactions == nil {
+		c.reactions = make(map[string][]Reaction)
+	}
+	rs := c.reactions[id]
+	found := false
+	for i, r := range rs {
+		if r.Text != reaction {
+			continue
+		}
+		found = true
+		for _, n := range r.Nicks {
+			if n == nick {
+				c.mu.Unlock()
+				return true
+			}
+		}
+		rs[i].Nicks = append(r.Nicks, nick)
+	}
+	if !found {
+		rs = append(rs, Reaction{Text: reaction, Nicks: []string{nick}})
+	}
+	c.reactions[id] = rs
+	const padding = "       "
+	l := padding + render(rs)
+	if c.meta[last].reactions {
+		c.lines[last] = l
+	} else {
+		c.insertLines(last+1, []string{l}, []lineMeta{{at: c.meta[last].at, msgid: id, reactions: true, prefix: len(padding)}})
+	}
+	c.mu.Unlock()
+	c.events.Emit("ui.DIRTY", map[string]interface{}{
+		"name": c.name,
+	})
+	return true
+}
+
+// A messageWindow writes to a Window as the lines of a message, optionally
+// quoting the message it replies to first.
+type messageWindow struct {
+	Window
+	info MessageInfo
+	at   time.Time
+	// token marks the lines written, if set.
+	token string
+	// insert is true to place the message in order of time, for messages
+	// played back from history.
+	insert bool
+	// quote is a line written before the message, if set.
+	quote string
+}
+
+func (w messageWindow) Write(p []byte) (int, error) {
+	if w.quote != "" {
+		p = append([]byte(w.quote+"\n"), p...)
+	}
+	mw, ok := w.Window.(interface {
+		WriteMessage(info MessageInfo, token string, at time.Time, insert bool, p []byte)
+	})
+	if !ok {
+		return w.Window.Write(p)
+	}
+	mw.WriteMessage(w.info, w.token, w.at, w.insert, p)
 	return len(p), nil
 }
 
+func (w messageWindow) WriteString(p string) (int, error) {
+	return w.Write([]byte(p))
+}
+
+// Notice does nothing for messages from history; they are not new.
+func (w messageWindow) Notice() {
+	if !w.insert {
+		w.Window.Notice()
+	}
+}
+
+// SetLog sets where a copy of the window's lines is written, closing any
+// previous log. A nil log disables logging.
+func (c *bufferedWindow) SetLog(log io.WriteCloser) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.log != nil {
+		c.log.Close()
+	}
+	c.log = log
+}
+
+// HasLog returns true if the window is being logged.
+func (c *bufferedWindow) HasLog() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.log != nil
+}
+
+func (c *bufferedWindow) Replace(token, line string) bool {
+	c.mu.Lock()
+	found := false
+	for i := len(c.meta) - 1; token != "" && i >= 0; i-- {
+		if c.meta[i].token == token {
+			c.lines[i] = c.lines[i][:c.meta[i].prefix] + line
+			found = true
+			break
+		}
+	}
+	c.mu.Unlock()
+	if found {
+		c.events.Emit("ui.DIRTY", map[string]interface{}{
+			"name": c.name,
+		})
+	}
+	return found
+}
+
 func (c *bufferedWindow) WriteString(p string) (n int, err error) {
 	return c.Write([]byte(p))
 }
@@ -188,33 +454,77 @@ func (c *StatusWindow) Title() string {
 
 type User struct {
 	string
+	// modes are the nick prefixes of the user, highest rank first.
 	modes string
+	// away is true if the user is marked as away.
+	away bool
 }
 
+// knownPrefixes are the nick prefixes recognized when the server has not
+// advertised PREFIX.
+const knownPrefixes = "~&@%+"
+
 func SomeUser(c string) User {
-	u := User{}
-	u.string = strings.ReplaceAll(strings.ReplaceAll(c, "@", ""), "+", "")
-	r := regexp.MustCompile("[^@+%]")
-	u.modes = r.ReplaceAllString(c, "")
-	return u
+	return ParseUser(c, knownPrefixes)
 }
 
-func (u User) String() string {
-	m := ""
-	if u.modes == "@" {
-		m = "[@](fg:cyan)"
-	} else if u.modes == "+" {
-		m = "[+](fg:yellow)"
+// ParseUser parses a nick with any number of the given prefixes, as sent
+// in NAMES replies. prefixes must be ordered from highest to lowest rank.
+func ParseUser(c string, prefixes string) User {
+	i := 0
+	for i < len(c) && strings.IndexByte(prefixes, c[i]) >= 0 {
+		i++
 	}
-	return fmt.Sprintf("%s%s", m, u.string)
+	return User{string: c[i:], modes: rankPrefixes(c[:i], prefixes)}
+}
+
+// rank returns the position of the user's highest prefix in prefixes.
+// Users without a prefix rank after everyone else.
+func (u User) rank(prefixes string) int {
+	if u.modes != "" {
+		if i := strings.IndexByte(prefixes, u.modes[0]); i >= 0 {
+			return i
+		}
+	}
+	return len(prefixes)
+}
+
+// Styled returns the nick with its highest prefix drawn in the configured
+// style. Away users are greyed out.
+func (u User) Styled(styles RankStyles) string {
+	nick := u.string
+	if u.away {
+		nick = "[" + nick + "](fg:grey)"
+	}
+	if u.modes == "" {
+		return nick
+	}
+	p := u.modes[:1]
+	if style, ok := styles[p]; ok {
+		return fmt.Sprintf("[%s](%s)%s", p, style, nick)
+	}
+	return p + nick
+}
+
+// defaultRankStyles are used to style users printed without the settings
+// at hand. They are shared and must not be modified.
+var defaultRankStyles = DefaultRankStyles()
+
+func (u User) String() string {
+	return u.Styled(defaultRankStyles)
 }
 
 type Channel struct {
 	bufferedWindow
 
-	topic string
-	modes string
-	users []User
+	topic      string
+	topicSetBy string
+	topicSetAt time.Time
+	modes      ChannelModes
+	users      *ChannelUsers
+
+	// cached entries of list modes like bans, keyed by mode character.
+	lists map[string][]ModeListEntry
 }
 
 func (c *Channel) Topic() string {
@@ -223,105 +533,108 @@ func (c *Channel) Topic() string {
 	return c.topic
 }
 
+// TopicSetBy returns who set the current topic and when, if known.
+func (c *Channel) TopicSetBy() (string, time.Time) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.topicSetBy, c.topicSetAt
+}
+
+// Modes returns the modes set on the channel, with the key masked.
 func (c *Channel) Modes() string {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
-	return c.modes
+	return c.modes.String(true)
 }
 
-func (c *Channel) SetUsers(users []string) {
+// ModeList returns the cached entries for the given list mode.
+func (c *Channel) ModeList(mode string) ([]ModeListEntry, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	l, ok := c.lists[mode]
+	return append([]ModeListEntry{}, l...), ok
+}
+
+// SetModeList replaces the cached entries for the given list mode.
+func (c *Channel) SetModeList(mode string, entries []ModeListEntry) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	if c.lists == nil {
+		c.lists = make(map[string][]ModeListEntry)
+	}
+	c.lists[mode] = entries
+}
+
+// SetUsers replaces the users in the channel with the nicks from a NAMES
+// reply. prefixes are the nick prefixes supported by the server, ordered by rank.
+func (c *Channel) SetUsers(users []string, prefixes string) {
 	r := make([]User, len(users))
 	for i, u := range users {
-		r[i] = SomeUser(u)
+		r[i] = ParseUser(u, prefixes)
 	}
-	c.users = r
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.users.Reset(r, prefixes)
 }
 
+// Users returns the nick of every user in the channel, sorted by rank.
 func (c *Channel) Users() []string {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
-	t := make([]string, len(c.users))
-	for i, u := range c.users {
-		t[i] = u.string
-	}
-	return t
+	return c.users.Nicks()
 }
 
-func (c *Channel) UserList() []string {
+// UserEntries returns every user in the channel, sorted by rank.
+func (c *Channel) UserEntries() []User {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
-	t := make([]User, len(c.users))
-	copy(t, c.users)
-	sort.SliceStable(t, func(i, j int) bool {
-		ui := t[i]
-		uj := t[j]
-		if ui.modes == "@" && uj.modes != "@" {
-			return true
-		} else if uj.modes == "@" && ui.modes != "@" {
-			return false
-		}
-		if ui.modes == "+" && uj.modes != "+" {
-			return true
-		} else if uj.modes == "+" && ui.modes != "+" {
-			return false
-		}
-		return strings.Compare(ui.string, uj.string) < 0
-	})
-	res := make([]string, len(c.users))
-	for i, u := range t {
-		res[i] = u.String()
-	}
-	return res
+	return c.users.Slice(0, c.users.Len())
 }
 
-func (c *Channel) userIndex(name string) int {
-	for i := 0; i < len(c.users); i++ {
-		if c.users[i].string == name {
-			return i
-		}
-	}
-	return -1
+// UserList returns the styled rows of the user list, sorted by rank.
+// Rows are only styled as they are requested.
+func (c *Channel) UserList(styles RankStyles) widget.RowSource {
+	return channelUserRows{c, styles}
 }
 
 func (c *Channel) AddUser(user User) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	if idx := c.userIndex(user.string); idx >= 0 {
-		c.users[idx].modes = user.modes
-		return
-	}
-	c.users = append(c.users, user)
+	c.users.Add(user)
 }
 
 func (c *Channel) UpdateUser(name, newName string) bool {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	if idx := c.userIndex(name); idx >= 0 {
-		c.users[idx].string = newName
-		return true
-	}
-	return false
+	return c.users.Rename(name, newName)
 }
 
 func (c *Channel) DeleteUser(name string) bool {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	if idx := c.userIndex(name); idx >= 0 {
-		c.users = append(c.users[:idx], c.users[idx+1:]...)
-		return true
-	}
-	return false
+	return c.users.Remove(name)
+}
+
+// UserModes returns the prefix characters of the given user, such as "@".
+func (c *Channel) UserModes(name string) (string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	u, ok := c.users.Get(name)
+	return u.modes, ok
+}
+
+// SetUserAway changes whether the given user is shown as away.
+func (c *Channel) SetUserAway(name string, away bool) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.users.SetAway(name, away)
 }
 
 func (c *Channel) HasUser(name string) bool {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
-	if idx := c.userIndex(name); idx >= 0 {
-		return true
-	}
-	return false
+	_, ok := c.users.Get(name)
+	return ok
 }
 
 type DirectMessage struct {
@@ -0,0 +1,235 @@
+package squirssi
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"code.dopame.me/veonik/squircy3/event"
+)
+
+// A ChannelListEntry is a single channel returned by a LIST query.
+type ChannelListEntry struct {
+	Name  string
+	Users int
+	Topic string
+}
+
+// ChannelListOptions limit which entries are shown in a ChannelList.
+type ChannelListOptions struct {
+	MinUsers int
+	// MaxUsers is ignored if zero or less.
+	MaxUsers int
+	// Pattern is a glob matched against channel names.
+	Pattern string
+}
+
+// channelListContext is the number of entries rendered on either side of
+// the selected entry. Channel lists can contain tens of thousands of
+// entries, only render what might actually be visible.
+const channelListContext = 200
+
+// A ChannelList is a Window for browsing the results of /list.
+// Entries are sorted by user count and can be filtered by typing in the
+// input box while the window is active.
+type ChannelList struct {
+	bufferedWindow
+
+	opts    ChannelListOptions
+	pattern *regexp.Regexp
+
+	entries  []ChannelListEntry
+	view     []ChannelListEntry
+	filter   string
+	selected int
+	complete bool
+	dirty    bool
+}
+
+func NewChannelList(events *event.Dispatcher) *ChannelList {
+	return &ChannelList{bufferedWindow: newBufferedWindow("list", events)}
+}
+
+func (c *ChannelList) padding() int {
+	return 0
+}
+
+// Reset clears the ChannelList and applies the given options to
+// entries added from now on.
+func (c *ChannelList) Reset(opts ChannelListOptions) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.opts = opts
+	c.pattern = nil
+	if opts.Pattern != "" {
+		c.pattern = regexp.MustCompile("(?i)" + globToRegexp(opts.Pattern))
+	}
+	c.entries = nil
+	c.view = nil
+	c.filter = ""
+	c.selected = 0
+	c.complete = false
+	c.dirty = true
+}
+
+// Add adds an entry to the list, if it matches the list's options.
+func (c *ChannelList) Add(e ChannelListEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e.Users < c.opts.MinUsers || (c.opts.MaxUsers > 0 && e.Users > c.opts.MaxUsers) {
+		return
+	}
+	if c.pattern != nil && !c.pattern.MatchString(e.Name) {
+		return
+	}
+	c.entries = append(c.entries, e)
+	c.dirty = true
+	c.hasUnseen = true
+}
+
+// Len returns the total number of entries in the list.
+func (c *ChannelList) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.entries)
+}
+
+// Complete marks the list as fully received.
+func (c *ChannelList) Complete() {
+	c.mu.Lock()
+	c.complete = true
+	c.dirty = true
+	c.hasUnseen = true
+	c.mu.Unlock()
+	c.events.Emit("ui.DIRTY", nil)
+}
+
+// Filter returns the current filter.
+func (c *ChannelList) Filter() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.filter
+}
+
+// SetFilter limits the visible entries to those containing filter in
+// their name or topic.
+func (c *ChannelList) SetFilter(filter string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.filter = filter
+	c.selected = 0
+	c.dirty = true
+}
+
+// Selected returns the currently selected entry.
+func (c *ChannelList) Selected() (ChannelListEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.update()
+	if c.selected < 0 || c.selected >= len(c.view) {
+		return ChannelListEntry{}, false
+	}
+	return c.view[c.selected], true
+}
+
+// update sorts and filters the entries, if necessary.
+// c.mu must be held for writing.
+func (c *ChannelList) update() {
+	if !c.dirty {
+		return
+	}
+	sort.SliceStable(c.entries, func(i, j int) bool {
+		if c.entries[i].Users != c.entries[j].Users {
+			return c.entries[i].Users > c.entries[j].Users
+		}
+		return c.entries[i].Name < c.entries[j].Name
+	})
+	if c.filter == "" {
+		c.view = c.entries
+	} else {
+		f := strings.ToLower(c.filter)
+		c.view = nil
+		for _, e := range c.entries {
+			if strings.Contains(strings.ToLower(e.Name), f) || strings.Contains(strings.ToLower(e.Topic), f) {
+				c.view = append(c.view, e)
+			}
+		}
+	}
+	if c.selected >= len(c.view) {
+		c.selected = len(c.view) - 1
+	}
+	if c.selected < 0 {
+		c.selected = 0
+	}
+	c.dirty = false
+}
+
+// window returns the first entry index to render.
+// c.mu must be held.
+func (c *ChannelList) window() int {
+	start := c.selected - channelListContext
+	if start < 0 {
+		start = 0
+	}
+	return start
+}
+
+func (c *ChannelList) Lines() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.update()
+	status := "listing..."
+	if c.complete {
+		status = fmt.Sprintf("%d of %d channels", len(c.view), len(c.entries))
+	}
+	if c.filter != "" {
+		status += fmt.Sprintf(", filter: %s", c.filter)
+	}
+	res := []string{fmt.Sprintf("[%s %s  %s](mod:bold) [(%s)](fg:grey)", padRight("Channel", 30), padLeft("Users", 6), "Topic", status)}
+	start := c.window()
+	end := c.selected + channelListContext
+	if end > len(c.view) {
+		end = len(c.view)
+	}
+	for i := start; i < end; i++ {
+		e := c.view[i]
+		name := padRight(e.Name, 30)
+		if i == c.selected {
+			name = "[" + name + "](mod:reverse)"
+		}
+		topic := e.Topic
+		if r := []rune(topic); len(r) > 300 {
+			topic = string(r[:300])
+		}
+		res = append(res, fmt.Sprintf("%s %s  %s\x0F", name, padLeft(fmt.Sprintf("%d", e.Users), 6), topic))
+	}
+	return res
+}
+
+func (c *ChannelList) CurrentLine() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.update()
+	// +1 for the header
+	return c.selected - c.window() + 1
+}
+
+func (c *ChannelList) ScrollTo(pos int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.update()
+	if pos < 0 {
+		// pinned to the end
+		c.selected = len(c.view) - 1
+	} else {
+		c.selected = c.window() + pos - 1
+	}
+	if c.selected < 0 {
+		c.selected = 0
+	}
+}
+
+func (c *ChannelList) AutoScroll() bool {
+	return false
+}
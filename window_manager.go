@@ -2,6 +2,7 @@ package squirssi
 
 import (
 	"fmt"
+	"io"
 	"sync"
 
 	"code.dopame.me/veonik/squircy3/event"
@@ -16,13 +17,14 @@ type WindowManager struct {
 
 	status *StatusWindow
 
-	events *event.Dispatcher
+	events  *event.Dispatcher
+	casemap *CaseMapper
 
 	mu sync.RWMutex
 }
 
-func NewWindowManager(ev *event.Dispatcher) *WindowManager {
-	wm := &WindowManager{events: ev}
+func NewWindowManager(ev *event.Dispatcher, casemap *CaseMapper) *WindowManager {
+	wm := &WindowManager{events: ev, casemap: casemap}
 	wm.status = &StatusWindow{bufferedWindow: newBufferedWindow("status", ev)}
 	wm.windows = []Window{wm.status}
 	return wm
@@ -86,7 +88,7 @@ func (wm *WindowManager) NamedOrActive(name string) Window {
 	wm.mu.RLock()
 	defer wm.mu.RUnlock()
 	for _, w := range wm.windows {
-		if w.Title() == name {
+		if wm.casemap.Equal(w.Title(), name) {
 			win = w
 			break
 		}
@@ -103,7 +105,7 @@ func (wm *WindowManager) Named(name string) Window {
 	wm.mu.RLock()
 	defer wm.mu.RUnlock()
 	for _, w := range wm.windows {
-		if w.Title() == name {
+		if wm.casemap.Equal(w.Title(), name) {
 			win = w
 			break
 		}
@@ -120,6 +122,18 @@ func (wm *WindowManager) Index(idx int) Window {
 	return wm.windows[idx]
 }
 
+// IndexOf returns the index of the given window, or -1 if it is not open.
+func (wm *WindowManager) IndexOf(win Window) int {
+	wm.mu.RLock()
+	defer wm.mu.RUnlock()
+	for i, w := range wm.windows {
+		if w == win {
+			return i
+		}
+	}
+	return -1
+}
+
 func (wm *WindowManager) SelectIndex(idx int) {
 	wm.mu.Lock()
 	defer wm.mu.Unlock()
@@ -165,6 +179,9 @@ func (wm *WindowManager) CloseIndex(ch int) {
 		logrus.Warnf("failed to close window; no window #%d", ch)
 		return
 	}
+	if l, ok := wm.windows[ch].(interface{ SetLog(io.WriteCloser) }); ok {
+		l.SetLog(nil)
+	}
 	wm.windows = append(wm.windows[:ch], wm.windows[ch+1:]...)
 	if ch >= len(wm.windows) {
 		wm.activeIndex = len(wm.windows) - 1
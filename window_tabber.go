@@ -33,21 +33,30 @@ func (t *TabCompleter) Clear() {
 	t.active = false
 }
 
+// Reset begins completing the last word of input with the users in window.
 func (t *TabCompleter) Reset(input string, window Window) string {
+	var candidates []string
+	if wul, ok := window.(WindowWithUserList); ok {
+		candidates = wul.Users()
+	}
+	return t.ResetCandidates(input, candidates, true)
+}
+
+// ResetCandidates begins completing the last word of input with candidates.
+// If nickSuffix is true, completing the first word of input appends ": ".
+func (t *TabCompleter) ResetCandidates(input string, candidates []string, nickSuffix bool) string {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 	parts := strings.Split(input, " ")
 	t.match = parts[len(parts)-1]
 	t.extra = ""
-	if t.match == parts[0] {
+	if nickSuffix && len(parts) == 1 {
 		t.extra = ": "
 	}
 	var m []string
-	if wul, ok := window.(WindowWithUserList); ok {
-		for _, v := range wul.Users() {
-			if strings.HasPrefix(v, t.match) {
-				m = append(m, v+t.extra)
-			}
+	for _, v := range candidates {
+		if strings.HasPrefix(v, t.match) {
+			m = append(m, v+t.extra)
 		}
 	}
 	// put the match on the end of the stack so we can tab back to it.
@@ -56,7 +65,12 @@ func (t *TabCompleter) Reset(input string, window Window) string {
 	t.matches = m
 	t.pos = 0
 	t.active = true
-	return strings.Replace(input, t.match, t.matches[t.pos], 1)
+	return t.replace()
+}
+
+// replace substitutes the current match for the word being completed.
+func (t *TabCompleter) replace() string {
+	return t.input[:len(t.input)-len(t.match)] + t.matches[t.pos]
 }
 
 func (t *TabCompleter) Tab() string {
@@ -69,5 +83,5 @@ func (t *TabCompleter) Tab() string {
 	if t.pos >= len(t.matches) {
 		t.pos = 0
 	}
-	return strings.Replace(t.input, t.match, t.matches[t.pos], 1)
+	return t.replace()
 }
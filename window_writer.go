@@ -29,6 +29,23 @@ func padRight(s string, padTo int) string {
 	return s
 }
 
+// humanDuration formats d in a short, human friendly way.
+func humanDuration(d time.Duration) string {
+	if d < 0 {
+		d = -d
+	}
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh%dm", int(d.Hours()), int(d.Minutes())%60)
+	default:
+		return fmt.Sprintf("%dd%dh", int(d.Hours())/24, int(d.Hours())%24)
+	}
+}
+
 func padLeftStyled(s StyledString, padTo int) string {
 	ml := len(s.string)
 	res := s.string
@@ -134,9 +151,14 @@ func (s StyledString) String() string {
 
 var basePrefix = Unstyled("* ")
 
-func WritePrefixed(win Window, prefix StyledString, message string) error {
+// formatPrefixed returns the line WritePrefixed writes.
+func formatPrefixed(win Window, prefix StyledString, message string) string {
 	padding := padLeftStyled(prefix, win.padding())
-	_, err := win.WriteString(fmt.Sprintf("%s[│](fg:grey) %s", padding, message))
+	return fmt.Sprintf("%s[│](fg:grey) %s", padding, message)
+}
+
+func WritePrefixed(win Window, prefix StyledString, message string) error {
+	_, err := win.WriteString(formatPrefixed(win, prefix, message))
 	return err
 }
 
@@ -149,7 +171,7 @@ func WriteQuit(wm *WindowManager, nick Nick, message string) {
 			}
 			continue
 		}
-		if win.Title() == nick.string {
+		if wm.casemap.Equal(win.Title(), nick.string) {
 			// direct message with nick, update title and print there
 			if err := WritePrefixed(win, basePrefix, fmt.Sprintf("%s quit (%s)", nick, message)); err != nil {
 				logrus.Warnf("%s: failed to write user quit: %s", win.Title(), err)
@@ -167,7 +189,7 @@ func WriteQuit(wm *WindowManager, nick Nick, message string) {
 func WriteNick(wm *WindowManager, nick Nick, newNick Nick) {
 	wins := wm.Windows()
 	for _, win := range wins {
-		if win.Title() == nick.string {
+		if wm.casemap.Equal(win.Title(), nick.string) {
 			// direct message with nick, update title and print there
 			if dm, ok := win.(*DirectMessage); ok {
 				dm.mu.Lock()
@@ -191,14 +213,67 @@ func WriteNick(wm *WindowManager, nick Nick, newNick Nick) {
 	}
 }
 
-func WriteWhois(win Window, nick string, args []string) {
-	m := strings.Join(args, " ")
-	if win == nil {
-		logrus.Infof("WHOIS %s => %s", nick, m)
-		return
+// whoisChannel styles the prefix of a channel from a WHOIS reply.
+func whoisChannel(channel, prefixes string, styles RankStyles) string {
+	u := ParseUser(channel, prefixes)
+	if u.modes == "" {
+		return channel
+	}
+	// channel names may start with a prefix character too
+	if strings.IndexAny(u.string, "#&!+") != 0 {
+		return channel
+	}
+	return u.Styled(styles)
+}
+
+func WriteWhois(win Window, r WhoisResult, prefixes string, styles RankStyles) {
+	prefix := Styled("WHOIS", "fg:grey100,mod:bold")
+	lines := []string{fmt.Sprintf("[%s](mod:bold) (%s@%s)", r.Nick, r.User, r.Host)}
+	field := func(name, value string) {
+		lines = append(lines, fmt.Sprintf("  [%s](fg:grey) %s", padRight(name, 9), value))
 	}
-	if err := WritePrefixed(win, basePrefix, fmt.Sprintf("WHOIS => %s", m)); err != nil {
-		logrus.Warnf("%s: failed to write whois result message: %s", win.Title(), err)
+	field("name", r.RealName)
+	if r.Account != "" {
+		field("account", r.Account)
+	}
+	if len(r.Channels) > 0 {
+		chans := make([]string, len(r.Channels))
+		for i, c := range r.Channels {
+			chans[i] = whoisChannel(c, prefixes, styles)
+		}
+		field("channels", strings.Join(chans, " "))
+	}
+	if r.Server != "" {
+		field("server", fmt.Sprintf("%s (%s)", r.Server, r.ServerInfo))
+	}
+	if r.Operator != "" {
+		field("operator", r.Operator)
+	}
+	if r.Secure {
+		field("tls", "connected using a secure connection")
+	}
+	if r.Idle > 0 || !r.SignOn.IsZero() {
+		idle := fmt.Sprintf("idle %s", humanDuration(r.Idle))
+		if !r.SignOn.IsZero() {
+			idle += fmt.Sprintf(", signed on %s ago (%s)", humanDuration(time.Since(r.SignOn)), r.SignOn.Format("2006-01-02 15:04"))
+		}
+		field("idle", idle)
+	}
+	if r.Away {
+		field("away", r.AwayMessage)
+	}
+	for _, l := range lines {
+		if err := WritePrefixed(win, prefix, l); err != nil {
+			logrus.Warnf("%s: failed to write whois result: %s", win.Title(), err)
+			return
+		}
+	}
+}
+
+func WriteWhowas(win Window, nick, userhost, realName string) {
+	prefix := Styled("WHOWAS", "fg:grey100,mod:bold")
+	if err := WritePrefixed(win, prefix, fmt.Sprintf("[%s](mod:bold) was %s (%s)", nick, userhost, realName)); err != nil {
+		logrus.Warnf("%s: failed to write whowas result: %s", win.Title(), err)
 	}
 }
 
@@ -258,6 +333,38 @@ func Write332(win Window, topic string) {
 	}
 }
 
+func Write333(win Window, setBy string, setAt time.Time) {
+	if err := WritePrefixed(win, basePrefix, fmt.Sprintf("Topic set by %s on [%s](mod:bold)", setBy, setAt.Format("Mon Jan 2 15:04:05 2006"))); err != nil {
+		logrus.Warnf("%s: failed to write topic message: %s", win.Title(), err)
+	}
+}
+
+func WriteTopicHistory(win Window, channel string, entries []TopicEntry) {
+	if len(entries) == 0 {
+		if err := WritePrefixed(win, basePrefix, fmt.Sprintf("No topic history for [%s](mod:bold)", channel)); err != nil {
+			logrus.Warnf("%s: failed to write topic history: %s", win.Title(), err)
+		}
+		return
+	}
+	if err := WritePrefixed(win, basePrefix, fmt.Sprintf("Topic history for [%s](mod:bold):", channel)); err != nil {
+		logrus.Warnf("%s: failed to write topic history: %s", win.Title(), err)
+	}
+	for i, e := range entries {
+		setBy := e.SetBy
+		if setBy == "" {
+			setBy = "unknown"
+		}
+		when := "unknown"
+		if !e.SetAt.IsZero() {
+			when = e.SetAt.Format("2006-01-02 15:04")
+		}
+		msg := fmt.Sprintf("[%d](mod:bold) %s [(%s, %s)](fg:grey)", i, e.Topic, setBy, when)
+		if err := WritePrefixed(win, basePrefix, msg); err != nil {
+			logrus.Warnf("%s: failed to write topic history: %s", win.Title(), err)
+		}
+	}
+}
+
 func WriteJoin(win Window, nick Nick) {
 	if err := WritePrefixed(win, basePrefix, fmt.Sprintf("%s joined [%s](mod:bold)", nick.String(), win.Title())); err != nil {
 		logrus.Warnf("%s: failed to write join message: %s", win.Title(), err)
@@ -288,11 +395,6 @@ func WriteMode(win Window, nick Nick, mode string) {
 }
 
 func WriteTopic(win Window, nick Nick, topic string) {
-	if ch, ok := win.(*Channel); ok {
-		ch.mu.Lock()
-		ch.topic = topic
-		ch.mu.Unlock()
-	}
 	if err := WritePrefixed(win, basePrefix, fmt.Sprintf("%s changed topic on [%s](mod:bold) to: %s", nick.String(), win.Title(), topic)); err != nil {
 		logrus.Warnf("%s: failed to write topic message: %s", win.Title(), err)
 	}
@@ -350,6 +452,46 @@ func WritePrivmsg(win Window, nick Nick, message Message) {
 	}
 }
 
+// A SendState describes whether the server has accepted a message we sent.
+type SendState int
+
+const (
+	SendConfirmed SendState = iota
+	SendPending
+	SendFailed
+)
+
+func (s SendState) String() string {
+	switch s {
+	case SendPending:
+		return " […](fg:grey)"
+	case SendFailed:
+		return " [✗ not sent](fg:red)"
+	}
+	return ""
+}
+
+func ownMessageLine(win Window, nick Nick, message Message, action bool, state SendState) string {
+	if action {
+		return formatPrefixed(win, basePrefix, fmt.Sprintf("%s %s%s", nick.String(), message.String(), state))
+	}
+	return formatPrefixed(win, nick.Styled(), message.String()+state.String())
+}
+
+// WriteOwnMessage writes a message we sent. Its state can be changed later
+// with UpdateOwnMessage if win marks the line with a token.
+func WriteOwnMessage(win Window, nick Nick, message Message, action bool, state SendState) {
+	if _, err := win.WriteString(ownMessageLine(win, nick, message, action, state)); err != nil {
+		logrus.Warnf("%s: failed to write own message: %s", win.Title(), err)
+	}
+}
+
+// UpdateOwnMessage changes the state shown for the line written by
+// WriteOwnMessage with the given token.
+func UpdateOwnMessage(win Window, token string, nick Nick, message Message, action bool, state SendState) {
+	win.Replace(token, ownMessageLine(win, nick, message, action, state))
+}
+
 func WriteHelpGeneric(win Window, msg string) {
 	prefix := Styled("HELP", "fg:yellow,mod:bold")
 	if err := WritePrefixed(win, prefix, msg); err != nil {
@@ -396,3 +538,455 @@ func writeNotice(win Window, target Target, kind string, sent bool, message stri
 		}
 	}
 }
+
+func describeIgnore(ig Ignore) string {
+	m := fmt.Sprintf("[%s](mod:bold) (%s)", ig.Mask, ig.Levels)
+	if ig.Regexp {
+		m += " regexp"
+	}
+	if len(ig.Channels) > 0 {
+		m += " in " + strings.Join(ig.Channels, ", ")
+	}
+	if !ig.Expires.IsZero() {
+		m += " until " + ig.Expires.Format("2006-01-02 15:04")
+	}
+	return m
+}
+
+func WriteIgnore(win Window, ig Ignore) {
+	if err := WritePrefixed(win, basePrefix, "Ignoring "+describeIgnore(ig)); err != nil {
+		logrus.Warnf("%s: failed to write ignore message: %s", win.Title(), err)
+	}
+}
+
+func WriteUnignore(win Window, ig Ignore) {
+	if err := WritePrefixed(win, basePrefix, "No longer ignoring "+describeIgnore(ig)); err != nil {
+		logrus.Warnf("%s: failed to write unignore message: %s", win.Title(), err)
+	}
+}
+
+func WriteIgnoreList(win Window, ignores []Ignore) {
+	if len(ignores) == 0 {
+		if err := WritePrefixed(win, basePrefix, "No ignores are set"); err != nil {
+			logrus.Warnf("%s: failed to write ignore list: %s", win.Title(), err)
+		}
+		return
+	}
+	for i, ig := range ignores {
+		if err := WritePrefixed(win, Unstyled(fmt.Sprintf("%d", i)), describeIgnore(ig)); err != nil {
+			logrus.Warnf("%s: failed to write ignore list: %s", win.Title(), err)
+		}
+	}
+}
+
+func WriteSetting(win Window, name, value string) {
+	if err := WritePrefixed(win, basePrefix, fmt.Sprintf("[%s](mod:bold) = %s", name, value)); err != nil {
+		logrus.Warnf("%s: failed to write setting: %s", win.Title(), err)
+	}
+}
+
+func WriteAway(win Window, nick Nick, message string) {
+	if err := WritePrefixed(win, basePrefix, fmt.Sprintf("%s is away: %s", nick, message)); err != nil {
+		logrus.Warnf("%s: failed to write away message: %s", win.Title(), err)
+	}
+}
+
+func WriteMarkedAway(win Window, message string) {
+	if message != "" {
+		message = ": " + message
+	}
+	if err := WritePrefixed(win, basePrefix, "You have been marked as being away"+message); err != nil {
+		logrus.Warnf("%s: failed to write away message: %s", win.Title(), err)
+	}
+}
+
+func WriteBack(win Window, away time.Duration, logged int) {
+	m := fmt.Sprintf("You are no longer marked as being away (away for %s)", away.Round(time.Second))
+	if logged > 0 {
+		s := "s"
+		if logged == 1 {
+			s = ""
+		}
+		m += fmt.Sprintf(", [%d message%s](mod:bold) logged in [awaylog](mod:bold)", logged, s)
+	}
+	if err := WritePrefixed(win, basePrefix, m); err != nil {
+		logrus.Warnf("%s: failed to write back message: %s", win.Title(), err)
+	}
+}
+
+func WriteAwayLog(win Window, target string, nick Nick, message Message) {
+	if err := WritePrefixed(win, Unstyled(target), fmt.Sprintf("<%s> %s", nick.string, message.String())); err != nil {
+		logrus.Warnf("%s: failed to write away log: %s", win.Title(), err)
+	}
+}
+
+func WriteWho(win Window, mask string, results []WhoResult) {
+	prefix := Styled("WHO", "fg:grey100,mod:bold")
+	if err := WritePrefixed(win, prefix, fmt.Sprintf("Results for [%s](mod:bold):", mask)); err != nil {
+		logrus.Warnf("%s: failed to write who results: %s", win.Title(), err)
+		return
+	}
+	nickWidth := 4
+	hostWidth := 9
+	for _, r := range results {
+		if l := len(r.Nick); l > nickWidth {
+			nickWidth = l
+		}
+		if l := len(r.User) + len(r.Host) + 1; l > hostWidth {
+			hostWidth = l
+		}
+	}
+	for _, r := range results {
+		account := r.Account
+		if account == "" {
+			account = "-"
+		}
+		m := fmt.Sprintf(
+			"[%s](mod:bold) %s %s %s %s",
+			padRight(r.Nick, nickWidth),
+			padRight(r.User+"@"+r.Host, hostWidth),
+			padRight(r.Flags, 4),
+			padRight(account, 12),
+			r.RealName)
+		if err := WritePrefixed(win, prefix, m); err != nil {
+			logrus.Warnf("%s: failed to write who results: %s", win.Title(), err)
+			return
+		}
+	}
+	s := "s"
+	if len(results) == 1 {
+		s = ""
+	}
+	if err := WritePrefixed(win, prefix, fmt.Sprintf("End of WHO (%d user%s)", len(results), s)); err != nil {
+		logrus.Warnf("%s: failed to write who results: %s", win.Title(), err)
+	}
+}
+
+var modeListNames = map[string]string{
+	"b": "Bans",
+	"e": "Exceptions",
+	"I": "Invite exceptions",
+	"q": "Quiets",
+}
+
+func WriteModeList(win Window, mode string, entries []ModeListEntry) {
+	name, ok := modeListNames[mode]
+	if !ok {
+		name = "+" + mode + " list"
+	}
+	if len(entries) == 0 {
+		if err := WritePrefixed(win, basePrefix, fmt.Sprintf("%s for [%s](mod:bold): none", name, win.Title())); err != nil {
+			logrus.Warnf("%s: failed to write mode list: %s", win.Title(), err)
+		}
+		return
+	}
+	if err := WritePrefixed(win, basePrefix, fmt.Sprintf("%s for [%s](mod:bold):", name, win.Title())); err != nil {
+		logrus.Warnf("%s: failed to write mode list: %s", win.Title(), err)
+		return
+	}
+	for i, e := range entries {
+		m := "[" + e.Mask + "](mod:bold)"
+		if e.SetBy != "" {
+			m += " set by " + e.SetBy
+		}
+		if !e.SetAt.IsZero() {
+			m += " " + humanDuration(time.Since(e.SetAt)) + " ago"
+		}
+		if err := WritePrefixed(win, Unstyled(fmt.Sprintf("%d", i)), m); err != nil {
+			logrus.Warnf("%s: failed to write mode list: %s", win.Title(), err)
+			return
+		}
+	}
+}
+
+func describeChannelConfig(c ChannelConfig) string {
+	var opts []string
+	if c.AutoJoin {
+		opts = append(opts, "autojoin")
+	}
+	if c.AutoRejoin {
+		opts = append(opts, "rejoin after "+c.RejoinDelay.String())
+	}
+	if c.Key != "" {
+		opts = append(opts, "key")
+	}
+	if c.HideJoins {
+		opts = append(opts, "hide joins")
+	}
+	if c.HideParts {
+		opts = append(opts, "hide parts")
+	}
+	if c.Logging {
+		opts = append(opts, "logging")
+	}
+	if len(c.Highlights) > 0 {
+		opts = append(opts, "highlight "+strings.Join(c.Highlights, ","))
+	}
+	return fmt.Sprintf("[%s](mod:bold) [(%s)](fg:grey)", c.Name, strings.Join(opts, ", "))
+}
+
+func WriteChannelConfig(win Window, network string, c ChannelConfig) {
+	if err := WritePrefixed(win, basePrefix, fmt.Sprintf("Saved %s on [%s](mod:bold)", describeChannelConfig(c), network)); err != nil {
+		logrus.Warnf("%s: failed to write channel settings: %s", win.Title(), err)
+	}
+}
+
+func WriteChannelRemoved(win Window, network, channel string) {
+	if err := WritePrefixed(win, basePrefix, fmt.Sprintf("Removed [%s](mod:bold) from [%s](mod:bold)", channel, network)); err != nil {
+		logrus.Warnf("%s: failed to write channel settings: %s", win.Title(), err)
+	}
+}
+
+func WriteChannelConfigs(win Window, network string, channels []ChannelConfig) {
+	if len(channels) == 0 {
+		if err := WritePrefixed(win, basePrefix, fmt.Sprintf("No channels configured on [%s](mod:bold)", network)); err != nil {
+			logrus.Warnf("%s: failed to write channel settings: %s", win.Title(), err)
+		}
+		return
+	}
+	if err := WritePrefixed(win, basePrefix, fmt.Sprintf("Channels on [%s](mod:bold):", network)); err != nil {
+		logrus.Warnf("%s: failed to write channel settings: %s", win.Title(), err)
+	}
+	for _, c := range channels {
+		if err := WritePrefixed(win, basePrefix, describeChannelConfig(c)); err != nil {
+			logrus.Warnf("%s: failed to write channel settings: %s", win.Title(), err)
+		}
+	}
+}
+
+func WriteJoinError(win Window, channel, message, hint string) {
+	msg := fmt.Sprintf("Cannot join [%s](mod:bold): %s", channel, message)
+	if hint != "" {
+		msg += " [" + hint + "](fg:grey)"
+	}
+	if err := WritePrefixed(win, Styled("!", "fg:red,mod:bold"), msg); err != nil {
+		logrus.Warnf("%s: failed to write join error: %s", win.Title(), err)
+	}
+}
+
+func WriteNumeric(win Window, n Numeric, text string) {
+	var err error
+	if n.Error {
+		err = WritePrefixed(win, Styled("!", "fg:red,mod:bold"), text)
+	} else {
+		err = WritePrefixed(win, basePrefix, text)
+	}
+	if err != nil {
+		logrus.Warnf("%s: failed to write %s: %s", win.Title(), n.Name, err)
+	}
+}
+
+func WriteUnknownNumeric(win Window, code, text string) {
+	if err := WritePrefixed(win, Styled(code, "fg:gray"), text); err != nil {
+		logrus.Warnf("%s: failed to write numeric %s: %s", win.Title(), code, err)
+	}
+}
+
+// WriteNames prints the users of a channel in aligned columns that fit in
+// width characters, followed by a summary. prefixes are the nick prefixes
+// used by the server from highest to lowest rank; users ranked at least as
+// high as '@' are counted as ops and those with the lowest rank as voices.
+func WriteNames(win *Channel, users []User, prefixes string, width int, styles RankStyles) {
+	prefix := Styled("NAMES", "fg:grey100,mod:bold")
+	colWidth := 1
+	for _, u := range users {
+		if l := len(u.string) + 1; l > colWidth {
+			colWidth = l
+		}
+	}
+	// columns are separated by two spaces
+	cols := (width + 2) / (colWidth + 2)
+	if cols < 1 {
+		cols = 1
+	}
+	opRank := strings.IndexByte(prefixes, '@')
+	voiceRank := len(prefixes) - 1
+	var ops, halfops, voices, normal int
+	var row []string
+	flush := func() bool {
+		if len(row) == 0 {
+			return true
+		}
+		err := WritePrefixed(win, prefix, strings.Join(row, "  "))
+		row = row[:0]
+		if err != nil {
+			logrus.Warnf("%s: failed to write names: %s", win.Title(), err)
+			return false
+		}
+		return true
+	}
+	for _, u := range users {
+		cell := " " + u.string
+		if u.modes != "" {
+			cell = u.Styled(styles)
+			switch rank := strings.IndexByte(prefixes, u.modes[0]); {
+			case rank < 0:
+				normal++
+			case rank <= opRank || rank == 0:
+				ops++
+			case rank == voiceRank:
+				voices++
+			default:
+				halfops++
+			}
+		} else {
+			normal++
+		}
+		row = append(row, cell+strings.Repeat(" ", colWidth-len(u.string)-1))
+		if len(row) == cols && !flush() {
+			return
+		}
+	}
+	if !flush() {
+		return
+	}
+	summary := fmt.Sprintf("[%s](mod:bold): Total of %d nicks (%d ops, ", win.Title(), len(users), ops)
+	if halfops > 0 {
+		summary += fmt.Sprintf("%d halfops, ", halfops)
+	}
+	summary += fmt.Sprintf("%d voices, %d normal)", voices, normal)
+	if err := WritePrefixed(win, prefix, summary); err != nil {
+		logrus.Warnf("%s: failed to write names: %s", win.Title(), err)
+	}
+}
+
+func WriteNotify(win Window, nick, host string, online bool) {
+	win.Notice()
+	msg := fmt.Sprintf("[%s](mod:bold) has signed off", nick)
+	if online {
+		msg = fmt.Sprintf("[%s](mod:bold) has signed on", nick)
+		if host != "" {
+			msg = fmt.Sprintf("[%s](mod:bold) (%s) has signed on", nick, host)
+		}
+	}
+	if err := WritePrefixed(win, Styled("NOTIFY", "fg:green,mod:bold"), msg); err != nil {
+		logrus.Warnf("%s: failed to write notify message: %s", win.Title(), err)
+	}
+}
+
+func WriteNotifyChanged(win Window, network string, nicks []string, added bool) {
+	msg := fmt.Sprintf("Removed %s from the notify list of [%s](mod:bold)", strings.Join(nicks, ", "), network)
+	if added {
+		msg = fmt.Sprintf("Added %s to the notify list of [%s](mod:bold)", strings.Join(nicks, ", "), network)
+	}
+	if err := WritePrefixed(win, basePrefix, msg); err != nil {
+		logrus.Warnf("%s: failed to write notify list: %s", win.Title(), err)
+	}
+}
+
+func WriteNotifyList(win Window, network string, nicks []string, online []bool) {
+	prefix := Styled("NOTIFY", "fg:green,mod:bold")
+	if len(nicks) == 0 {
+		if err := WritePrefixed(win, prefix, fmt.Sprintf("The notify list of [%s](mod:bold) is empty", network)); err != nil {
+			logrus.Warnf("%s: failed to write notify list: %s", win.Title(), err)
+		}
+		return
+	}
+	if err := WritePrefixed(win, prefix, fmt.Sprintf("Notify list of [%s](mod:bold):", network)); err != nil {
+		logrus.Warnf("%s: failed to write notify list: %s", win.Title(), err)
+		return
+	}
+	for i, n := range nicks {
+		state := "[offline](fg:grey)"
+		if online[i] {
+			state = "[online](fg:green)"
+		}
+		if err := WritePrefixed(win, prefix, fmt.Sprintf("  %s %s", padRight(n, 16), state)); err != nil {
+			logrus.Warnf("%s: failed to write notify list: %s", win.Title(), err)
+			return
+		}
+	}
+}
+
+func WriteTLSInfo(win Window, info TLSInfo, pinning bool) {
+	prefix := Styled("TLS", "fg:grey100,mod:bold")
+	lines := []string{
+		fmt.Sprintf("[%s](mod:bold) using %s, %s", info.Server, info.Version, info.Cipher),
+	}
+	if pinning {
+		if info.Pinned {
+			lines = append(lines, "  certificate matches a pinned fingerprint")
+		} else {
+			lines = append(lines, "  [certificate does not match any pinned fingerprint](fg:red)")
+		}
+	}
+	for i, c := range info.Chain {
+		lines = append(lines,
+			fmt.Sprintf("  %d [%s](mod:bold)", i, c.Subject.String()),
+			fmt.Sprintf("    [issuer](fg:grey)  %s", c.Issuer.String()),
+			fmt.Sprintf("    [valid](fg:grey)   %s to %s", c.NotBefore.Format("2006-01-02"), c.NotAfter.Format("2006-01-02")),
+			fmt.Sprintf("    [sha256](fg:grey)  %s", Fingerprint(c)))
+	}
+	for _, l := range lines {
+		if err := WritePrefixed(win, prefix, l); err != nil {
+			logrus.Warnf("%s: failed to write tls info: %s", win.Title(), err)
+			return
+		}
+	}
+}
+
+func WriteAccount(win Window, nick Nick, account string) {
+	msg := fmt.Sprintf("[%s logged out](fg:grey)", nick.string)
+	if account != "" {
+		msg = fmt.Sprintf("[%s is now logged in as %s](fg:grey)", nick.string, account)
+	}
+	if err := WritePrefixed(win, basePrefix, msg); err != nil {
+		logrus.Warnf("%s: failed to write account change: %s", win.Title(), err)
+	}
+}
+
+func WriteChghost(win Window, nick Nick, userhost string) {
+	if err := WritePrefixed(win, basePrefix, fmt.Sprintf("[%s changed host to %s](fg:grey)", nick.string, userhost)); err != nil {
+		logrus.Warnf("%s: failed to write host change: %s", win.Title(), err)
+	}
+}
+
+func WriteSetname(win Window, nick Nick, realName string) {
+	if err := WritePrefixed(win, basePrefix, fmt.Sprintf("[%s changed their name to %s](fg:grey)", nick.string, realName)); err != nil {
+		logrus.Warnf("%s: failed to write name change: %s", win.Title(), err)
+	}
+}
+
+func WriteInvite(win Window, from Nick, to Nick, channel string) {
+	msg := fmt.Sprintf("%s invited %s to [%s](mod:bold)", from, to, channel)
+	if to.me {
+		win.Notice()
+		msg = fmt.Sprintf("%s invites you to [%s](mod:bold)", from, channel)
+	}
+	if err := WritePrefixed(win, basePrefix, msg); err != nil {
+		logrus.Warnf("%s: failed to write invite: %s", win.Title(), err)
+	}
+}
+
+// replyQuoteLength is how much of a message is quoted above a reply.
+const replyQuoteLength = 60
+
+// replyQuoteLine returns the line shown above a reply, quoting the start of
+// parent if it is known.
+func replyQuoteLine(win Window, parent MessageInfo, known bool) string {
+	if !known {
+		return formatPrefixed(win, Unstyled(""), "[↱ in reply to an earlier message](fg:grey)")
+	}
+	text := []rune(parent.Text)
+	if len(text) > replyQuoteLength {
+		text = append(text[:replyQuoteLength], '…')
+	}
+	quote := parent.Nick + ": " + string(text)
+	if parent.Action {
+		quote = "* " + parent.Nick + " " + string(text)
+	}
+	return formatPrefixed(win, Unstyled(""), fmt.Sprintf("[↱ %s](fg:grey)", quote))
+}
+
+// reactionsLine returns the line shown below a message with its reactions.
+func reactionsLine(win Window, rs []Reaction) string {
+	var parts []string
+	for _, r := range rs {
+		who := strings.Join(r.Nicks, ", ")
+		if len(r.Nicks) > 3 {
+			who = fmt.Sprintf("%d", len(r.Nicks))
+		}
+		parts = append(parts, fmt.Sprintf("%s [%s](fg:grey)", r.Text, who))
+	}
+	return formatPrefixed(win, Unstyled(""), strings.Join(parts, "  "))
+}